@@ -0,0 +1,255 @@
+package upnp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A SuspiciousMapping is a mapping that points somewhere it shouldn't.
+type SuspiciousMapping struct {
+	PortMapping
+	// Reason explains why the mapping is suspicious, e.g. "internal client is
+	// outside the LAN (192.168.1.0/24)".
+	Reason string
+}
+
+// An AuditReport categorizes the entries in a router's port mapping table.
+// Every mapping appears in exactly one of Ours, OtherHosts, Disabled, and
+// Suspicious; mappings may additionally appear in ExpiringSoon.
+type AuditReport struct {
+	// Ours are the enabled mappings that point at this host, i.e. the
+	// Device's internal IP.
+	Ours []PortMapping
+	// OtherHosts are the enabled mappings that point at other hosts on the
+	// LAN.
+	OtherHosts []PortMapping
+	// Disabled are the mappings that the router reports as disabled.
+	Disabled []PortMapping
+	// Suspicious are the mappings whose internal client is not a plausible
+	// LAN host: an invalid or broadcast address, an address outside the LAN
+	// (or, if the LAN is unknown, a public address), or the router itself.
+	// With WithLivenessProbe, mappings pointing at LAN hosts that do not
+	// respond are also included. Such mappings may be left over from a
+	// previous network configuration, or may have been created by malware to
+	// expose the router or to relay traffic elsewhere.
+	Suspicious []SuspiciousMapping
+	// ExpiringSoon are the mappings whose lease expires within the threshold
+	// passed to Audit.
+	ExpiringSoon []PortMapping
+}
+
+// Total returns the number of mappings in the report.
+func (r AuditReport) Total() int {
+	return len(r.Ours) + len(r.OtherHosts) + len(r.Disabled) + len(r.Suspicious)
+}
+
+type auditOptions struct {
+	probeTimeout time.Duration
+}
+
+// An AuditOption modifies the behavior of Audit.
+type AuditOption func(*auditOptions)
+
+// WithLivenessProbe checks whether the hosts that mappings point at actually
+// exist, reporting mappings for hosts that do not respond as Suspicious. A
+// host is considered alive if it appears in this host's neighbor (ARP) table,
+// or if it accepts or actively refuses a TCP connection to the mapping's
+// internal port within timeout. Hosts that silently drop traffic and have not
+// been seen recently may therefore be reported even though they exist. The
+// neighbor table is only consulted on Linux.
+func WithLivenessProbe(timeout time.Duration) AuditOption {
+	return func(o *auditOptions) { o.probeTimeout = timeout }
+}
+
+// Audit fetches the router's port mapping table and categorizes each mapping,
+// for use by security tooling and cleanup utilities. Leased mappings that
+// expire within expiringWithin are also reported in ExpiringSoon.
+//
+// The LAN is taken to be the subnet of the local interface with the Device's
+// internal IP. If no such interface exists (e.g. because the internal IP was
+// set via WithInternalIP), mappings are not checked against it. By default,
+// Audit does not contact the hosts that mappings point at, so mappings for
+// LAN addresses that no longer exist are reported in OtherHosts; use
+// WithLivenessProbe to detect them.
+func (d Device) Audit(ctx context.Context, expiringWithin time.Duration, opts ...AuditOption) (AuditReport, error) {
+	var o auditOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ms, err := d.Mappings(ctx)
+	if err != nil {
+		return AuditReport{}, err
+	}
+	lan, _ := localSubnet(d.internalIP)
+	var router netip.Addr
+	if u, err := url.Parse(d.client.Location()); err == nil {
+		router, _ = netip.ParseAddr(u.Hostname())
+	}
+
+	var dead map[string]bool
+	if o.probeTimeout > 0 {
+		dead = probeClients(ctx, ms, d.internalIP, lan, router, o.probeTimeout)
+	}
+
+	var r AuditReport
+	for _, m := range ms {
+		if reason := suspiciousReason(m.InternalClient, lan, router); reason != "" {
+			r.Suspicious = append(r.Suspicious, SuspiciousMapping{m, reason})
+		} else if dead[m.InternalClient] {
+			r.Suspicious = append(r.Suspicious, SuspiciousMapping{m, "internal client does not respond"})
+		} else if !m.Enabled {
+			r.Disabled = append(r.Disabled, m)
+		} else if m.InternalClient == d.internalIP {
+			r.Ours = append(r.Ours, m)
+		} else {
+			r.OtherHosts = append(r.OtherHosts, m)
+		}
+		if m.Lease > 0 && m.Lease <= expiringWithin {
+			r.ExpiringSoon = append(r.ExpiringSoon, m)
+		}
+	}
+	return r, nil
+}
+
+// suspiciousReason returns why client is not a plausible LAN host, or "" if it
+// is. lan and router are ignored if invalid.
+func suspiciousReason(client string, lan netip.Prefix, router netip.Addr) string {
+	addr, err := netip.ParseAddr(client)
+	if err != nil || !addr.Unmap().Is4() {
+		return "internal client is not an IPv4 address"
+	}
+	addr = addr.Unmap()
+	switch {
+	case addr.IsUnspecified() || addr.IsMulticast() || addr == netip.AddrFrom4([4]byte{255, 255, 255, 255}):
+		return "internal client is not a unicast address"
+	case addr.IsLoopback():
+		return "internal client is a loopback address"
+	case addr == router.Unmap():
+		return "internal client is the router itself"
+	case !lan.IsValid():
+		// without knowing the LAN, assume it uses private addresses
+		if !addr.IsPrivate() && !addr.IsLinkLocalUnicast() {
+			return "internal client is a public address"
+		}
+	case !lan.Contains(addr):
+		return "internal client is outside the LAN (" + lan.String() + ")"
+	case lan.Bits() < 31 && (addr == lan.Addr() || addr == broadcastAddr(lan)):
+		return "internal client is the LAN's network or broadcast address"
+	}
+	return ""
+}
+
+// probeClients returns the set of internal clients in ms that do not respond,
+// skipping this host and clients that are already suspicious.
+func probeClients(ctx context.Context, ms []PortMapping, self string, lan netip.Prefix, router netip.Addr, timeout time.Duration) map[string]bool {
+	ports := make(map[string]uint16)
+	for _, m := range ms {
+		if _, ok := ports[m.InternalClient]; !ok && m.InternalClient != self && suspiciousReason(m.InternalClient, lan, router) == "" {
+			ports[m.InternalClient] = m.InternalPort
+		}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	dead := make(map[string]bool)
+	for client, port := range ports {
+		wg.Add(1)
+		go func(client string, port uint16) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if !hostAlive(ctx, client, port, timeout) {
+				mu.Lock()
+				dead[client] = true
+				mu.Unlock()
+			}
+		}(client, port)
+	}
+	wg.Wait()
+	return dead
+}
+
+// hostAlive reports whether the host at ip appears to exist.
+func hostAlive(ctx context.Context, ip string, port uint16, timeout time.Duration) bool {
+	if inNeighborTable(ip) {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(int(port))))
+	if err == nil {
+		conn.Close()
+		return true
+	} else if isConnRefused(err) {
+		return true
+	}
+	// the connection attempt may have populated the neighbor table even if
+	// the host dropped the packet
+	return inNeighborTable(ip)
+}
+
+// inNeighborTable reports whether ip has a resolved entry in the kernel's ARP
+// table. It always returns false on systems other than Linux.
+func inNeighborTable(ip string) bool {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Scan() // skip header
+	for s.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 4 && fields[0] == ip && fields[2] != "0x0" && fields[3] != "00:00:00:00:00:00" {
+			return true
+		}
+	}
+	return false
+}
+
+// localSubnet returns the subnet of the local interface with the specified
+// IPv4 address.
+func localSubnet(ip string) (netip.Prefix, bool) {
+	want, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	for _, addr := range addrs {
+		x, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		a, ok := netip.AddrFromSlice(x.IP)
+		bits, _ := x.Mask.Size()
+		if ok && a.Unmap() == want.Unmap() {
+			if a.Is4In6() && bits >= 96 {
+				bits -= 96
+			}
+			return netip.PrefixFrom(a.Unmap(), bits).Masked(), true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// broadcastAddr returns the highest address in p, which must be IPv4.
+func broadcastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().As4()
+	for i := p.Bits(); i < 32; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	return netip.AddrFrom4(b)
+}
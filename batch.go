@@ -0,0 +1,95 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A BatchOp is a single operation performed by Batch.
+type BatchOp struct {
+	// Clear, if set, clears the mapping rather than forwarding it; the
+	// Description and Options are ignored.
+	Clear       bool
+	Port        uint16
+	Protocol    string
+	Description string
+	Options     []ForwardOption
+}
+
+// String implements fmt.Stringer.
+func (op BatchOp) String() string {
+	if op.Clear {
+		return fmt.Sprintf("clear %v port %v", op.Protocol, op.Port)
+	}
+	return fmt.Sprintf("forward %v port %v", op.Protocol, op.Port)
+}
+
+// A BatchResult is the outcome of a BatchOp.
+type BatchResult struct {
+	Op  BatchOp
+	Err error
+}
+
+// BatchResults are the outcomes of the operations performed by Batch, in the
+// same order as the operations.
+type BatchResults []BatchResult
+
+// Failed returns the results whose operations failed.
+func (rs BatchResults) Failed() BatchResults {
+	var failed BatchResults
+	for _, r := range rs {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// Err returns an error describing every failed operation, or nil if all of
+// them succeeded. The individual errors can be inspected with errors.Is and
+// errors.As.
+func (rs BatchResults) Err() error {
+	var errs []error
+	for _, r := range rs.Failed() {
+		errs = append(errs, fmt.Errorf("%v: %w", r.Op, r.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// Batch performs each of ops, returning the outcome of every operation rather
+// than stopping at the first failure. Up to concurrency operations are
+// performed at once; if concurrency is less than 2, or the Device was created
+// with WithSerializedRequests, they are performed one at a time, in order.
+// Concurrent operations may complete in any order, so a batch should not
+// contain multiple operations on the same port unless concurrency is 1. Note
+// that many routers handle concurrent requests poorly.
+func (d Device) Batch(ctx context.Context, ops []BatchOp, concurrency int) BatchResults {
+	if concurrency < 1 || d.client.IsSerialized() {
+		concurrency = 1
+	}
+	results := make(BatchResults, len(ops))
+	do := func(r *BatchResult) {
+		if err := ctx.Err(); err != nil {
+			r.Err = err
+		} else if r.Op.Clear {
+			r.Err = d.Clear(ctx, r.Op.Port, r.Op.Protocol)
+		} else {
+			r.Err = d.Forward(ctx, r.Op.Port, r.Op.Protocol, r.Op.Description, r.Op.Options...)
+		}
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range ops {
+		results[i].Op = ops[i]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(r *BatchResult) {
+			defer func() { <-sem; wg.Done() }()
+			do(r)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,67 @@
+package upnp
+
+import (
+	"sync"
+	"time"
+)
+
+// A DiscoveryCache remembers the Devices found by DiscoverAll (and Discover)
+// for as long as their SSDP responses remain valid, as specified by the
+// CACHE-CONTROL max-age directive. While any cached Device remains valid,
+// subsequent discoveries using the same cache skip the search and description
+// fetch entirely, returning the cached Devices instead. Devices whose
+// responses do not specify a max-age are not cached.
+//
+// Cached Devices retain the options they were discovered with, so a cache
+// should only be shared between discoveries using the same options. The zero
+// value is ready for use.
+type DiscoveryCache struct {
+	mu      sync.Mutex
+	entries []cacheEntry
+}
+
+type cacheEntry struct {
+	d       Device
+	expires time.Time
+}
+
+func (c *DiscoveryCache) add(d Device, maxAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := cacheEntry{d, time.Now().Add(maxAge)}
+	for i := range c.entries {
+		if c.entries[i].d.client.Identity() == d.client.Identity() && c.entries[i].d.Location() == d.Location() {
+			c.entries[i] = e
+			return
+		}
+	}
+	c.entries = append(c.entries, e)
+}
+
+// devices returns the unexpired Devices in the cache.
+func (c *DiscoveryCache) devices() []Device {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	valid := c.entries[:0]
+	for _, e := range c.entries {
+		if now.Before(e.expires) {
+			valid = append(valid, e)
+		}
+	}
+	c.entries = valid
+	ds := make([]Device, len(valid))
+	for i, e := range valid {
+		ds[i] = e.d
+	}
+	return ds
+}
+
+// Flush discards every cached Device, forcing the next discovery to search
+// the network. This is useful after a network change, e.g. when a laptop
+// joins a different Wi-Fi network.
+func (c *DiscoveryCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
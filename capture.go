@@ -0,0 +1,186 @@
+package upnp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"lukechampine.com/upnp/internal/safexml"
+)
+
+// An Exchange is a raw message, or request/response pair, recorded by a
+// Capture.
+type Exchange struct {
+	Time time.Time
+	// Protocol is "SSDP" or "HTTP".
+	Protocol string
+	// Remote is the address of the router, or of the multicast group that an
+	// SSDP search was sent to.
+	Remote string
+	// Request is the message sent, and Response is the message received. For
+	// SSDP, each packet is recorded as a separate Exchange, so one of the two
+	// is always nil. For HTTP, Response is nil if the request failed.
+	Request  []byte
+	Response []byte
+}
+
+// A Capture records the exact SSDP packets and HTTP messages (including device
+// descriptions and SOAP requests) exchanged with routers. Captures are
+// intended to be attached to bug reports concerning specific router models.
+// The zero value is ready for use.
+type Capture struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+func (c *Capture) add(e Exchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exchanges = append(c.exchanges, e)
+}
+
+// Exchanges returns every exchange recorded so far, in chronological order.
+func (c *Capture) Exchanges() []Exchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Exchange(nil), c.exchanges...)
+}
+
+// Reset discards all recorded exchanges.
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exchanges = nil
+}
+
+// WriteTo implements io.WriterTo, writing the recorded exchanges in a
+// human-readable format.
+func (c *Capture) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, e := range c.Exchanges() {
+		fmt.Fprintf(&buf, "=== %v %v %v\n", e.Time.Format(time.RFC3339Nano), e.Protocol, e.Remote)
+		if e.Request != nil {
+			fmt.Fprintf(&buf, ">>>\n%s\n", bytes.TrimSpace(e.Request))
+		}
+		if e.Response != nil {
+			fmt.Fprintf(&buf, "<<<\n%s\n", bytes.TrimSpace(e.Response))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.WriteTo(w)
+}
+
+// ReadCapture parses a Capture in the format written by WriteTo, e.g. one
+// attached to a bug report, so that it can be replayed via NewReplay.
+func ReadCapture(r io.Reader) (*Capture, error) {
+	c := new(Capture)
+	var cur *Exchange
+	var section *[]byte
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		if cur.Request != nil {
+			cur.Request = bytes.TrimSpace(cur.Request)
+		}
+		if cur.Response != nil {
+			cur.Response = bytes.TrimSpace(cur.Response)
+		}
+		c.exchanges = append(c.exchanges, *cur)
+		cur, section = nil, nil
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: messages are recorded with their original line endings, which
+	// must be preserved, e.g. for chunked bodies
+	for i, raw := range bytes.SplitAfter(data, []byte("\n")) {
+		line := i + 1
+		text := strings.TrimRight(string(raw), "\r\n")
+		switch {
+		case strings.HasPrefix(text, "=== "):
+			flush()
+			fields := strings.Fields(text[4:])
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %v: malformed exchange header", line)
+			}
+			t, err := time.Parse(time.RFC3339Nano, fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %v: invalid timestamp: %w", line, err)
+			}
+			cur = &Exchange{Time: t, Protocol: fields[1], Remote: fields[2]}
+		case cur != nil && text == ">>>":
+			cur.Request = []byte{}
+			section = &cur.Request
+		case cur != nil && text == "<<<":
+			cur.Response = []byte{}
+			section = &cur.Response
+		case section != nil:
+			*section = append(*section, raw...)
+		case strings.TrimSpace(text) != "":
+			return nil, fmt.Errorf("line %v: unexpected content outside of an exchange", line)
+		}
+	}
+	flush()
+	return c, nil
+}
+
+func (c *Capture) traceSSDP(sent bool, addr net.Addr, packet []byte) {
+	e := Exchange{Time: time.Now(), Protocol: "SSDP", Remote: addr.String()}
+	if sent {
+		e.Request = packet
+	} else {
+		e.Response = packet
+	}
+	c.add(e)
+}
+
+// captureTransport records each HTTP exchange that passes through it.
+type captureTransport struct {
+	base http.RoundTripper
+	c    *Capture
+}
+
+func (t captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := Exchange{Time: time.Now(), Protocol: "HTTP", Remote: req.URL.Host}
+	e.Request, _ = httputil.DumpRequestOut(req, true)
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		// DumpResponse buffers the entire body, so bound it by the largest
+		// document we would accept anyway
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, safexml.MaxSize+1), resp.Body}
+		e.Response, _ = httputil.DumpResponse(resp, true)
+	}
+	t.c.add(e)
+	return resp, err
+}
+
+// RoundTripper returns an http.RoundTripper that records each exchange that
+// passes through it in c before returning it. If base is nil,
+// http.DefaultTransport is used. This is useful for recording traffic from
+// an *http.Client passed to WithHTTPClient, or from the igd and soap
+// packages.
+func (c *Capture) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return captureTransport{base, c}
+}
+
+// captureClient returns a copy of client that records its exchanges in c.
+func captureClient(client *http.Client, c *Capture) *http.Client {
+	cc := *client
+	cc.Transport = c.RoundTripper(cc.Transport)
+	return &cc
+}
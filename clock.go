@@ -0,0 +1,19 @@
+package upnp
+
+import "lukechampine.com/upnp/internal/clock"
+
+// A Clock tells the time and creates timers. It is used by the background
+// machinery of this package (lease renewals, the Reconciler, event
+// subscriptions, Watch, and retry backoff), so that code built on it can be
+// tested deterministically, e.g. with upnptest.Clock, rather than by sleeping.
+// Timeouts on individual requests to the router always use the system clock.
+type Clock = clock.Clock
+
+// A Timer is a timer created by a Clock. It behaves like a *time.Timer.
+type Timer = clock.Timer
+
+// WithClock sets the clock used by the resulting Devices (and by Watch). The
+// default is the system clock.
+func WithClock(c Clock) Option {
+	return func(o *options) { o.clock = c }
+}
@@ -0,0 +1,327 @@
+// Command upnp manages port mappings on UPnP-enabled routers.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"lukechampine.com/upnp"
+)
+
+const usage = `Usage: upnp [flags] <command> [args]
+
+Commands:
+  discover [-timing]            list gateways on the local network
+  ip                            print the gateway's external IP
+  status                        print the gateway's connection status
+  list [flags]                  list the gateway's port mappings
+  audit [flags]                 categorize the gateway's port mappings and flag
+                                suspicious ones
+  forward [flags] port proto [desc]
+                                forward a port
+  clear port proto              clear a forwarded port
+  serve config.json             maintain the mappings in a config file until
+                                interrupted, then clear them
+
+Flags:
+`
+
+var (
+	deviceURL = flag.String("url", "", "connect to the gateway at this description URL instead of discovering one")
+	timeout   = flag.Duration("timeout", 10*time.Second, "timeout for each command")
+	verbose   = flag.Bool("v", false, "log SSDP and UPnP traffic to stderr")
+	capture   = flag.String("capture", "", "write the raw SSDP and HTTP traffic to this file, e.g. for a bug report")
+	replay    = flag.String("replay", "", "replay the HTTP traffic recorded by -capture instead of contacting a gateway")
+)
+
+var wire upnp.Capture
+
+func writeCapture() {
+	if *capture == "" {
+		return
+	}
+	f, err := os.Create(*capture)
+	if err == nil {
+		_, err = wire.WriteTo(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't write capture: %v\n", err)
+	}
+}
+
+// stderrLogger implements upnp.Logger.
+type stderrLogger struct{}
+
+func (stderrLogger) Debug(msg string, args ...interface{}) {
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&sb, " %v=%v", args[i], args[i+1])
+	}
+	log.Println(sb.String())
+}
+
+var rep *upnp.Replay
+
+func loadReplay() {
+	if *replay == "" {
+		return
+	}
+	f, err := os.Open(*replay)
+	check("couldn't open replay", err)
+	defer f.Close()
+	c, err := upnp.ReadCapture(f)
+	check("couldn't read replay", err)
+	rep, err = upnp.NewReplay(c)
+	check("couldn't read replay", err)
+}
+
+func options() []upnp.Option {
+	var opts []upnp.Option
+	if *verbose {
+		opts = append(opts, upnp.WithLogger(stderrLogger{}))
+	}
+	if *capture != "" {
+		opts = append(opts, upnp.WithCapture(&wire))
+	}
+	if rep != nil {
+		opts = append(opts, upnp.WithReplay(rep))
+	}
+	return opts
+}
+
+func check(ctx string, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", ctx, err)
+		writeCapture()
+		os.Exit(1)
+	}
+}
+
+func usageError(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	flag.Usage()
+	os.Exit(2)
+}
+
+func parsePort(s string) uint16 {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		usageError(fmt.Sprintf("invalid port %q", s))
+	}
+	return uint16(port)
+}
+
+func parsePolicy(s string) upnp.ForwardPolicy {
+	p := upnp.ForwardDefault
+	for p <= upnp.ForwardReplace && p.String() != s {
+		p++
+	}
+	if p > upnp.ForwardReplace {
+		usageError(fmt.Sprintf("invalid policy %q", s))
+	}
+	return p
+}
+
+func parseProto(s string) string {
+	proto, err := upnp.ParseProto(s)
+	if err != nil {
+		usageError(err.Error())
+	}
+	return proto
+}
+
+func connect(ctx context.Context) upnp.Device {
+	if *deviceURL != "" || rep != nil {
+		url := *deviceURL
+		if url == "" {
+			url = rep.Location()
+		}
+		d, err := upnp.Connect(ctx, url, options()...)
+		check("couldn't connect to gateway", err)
+		return d
+	}
+	d, err := upnp.Discover(ctx, options()...)
+	check("couldn't discover gateway", err)
+	return d
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usageError("no command specified")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	defer writeCapture()
+	loadReplay()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "discover":
+		fs := flag.NewFlagSet("discover", flag.ExitOnError)
+		timing := fs.Bool("timing", false, "print how long each device took to respond and to fetch its description")
+		fs.Parse(args)
+		results, err := upnp.DiscoverResultsCtx(ctx, options()...)
+		check("couldn't discover gateways", err)
+		for r := range results {
+			if *timing {
+				fmt.Fprintf(os.Stderr, "%v: rtt=%v queued=%v fetch=%v services=%v server=%q\n",
+					r.Location, r.RTT.Round(time.Millisecond), r.Queued.Round(time.Millisecond), r.Fetch.Round(time.Millisecond), r.Services, r.Server)
+			}
+			if r.Err != nil {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", r.Location, r.Err)
+				continue
+			}
+			d := r.Device
+			name := d.FriendlyName()
+			if name == "" {
+				name = d.ModelName()
+			}
+			fmt.Printf("%v\t%v\n", d.Location(), name)
+		}
+
+	case "ip":
+		ip, err := connect(ctx).ExternalIP(ctx)
+		check("couldn't get external IP", err)
+		fmt.Println(ip)
+
+	case "status":
+		d := connect(ctx)
+		s, err := d.Status(ctx)
+		check("couldn't get status", err)
+		fmt.Println("Gateway:    ", d.Location())
+		fmt.Println("Model:      ", strings.TrimSpace(d.Manufacturer()+" "+d.ModelName()+" "+d.ModelNumber()))
+		if s := d.ServerString(); s != "" {
+			fmt.Println("Server:     ", s)
+		}
+		fmt.Println("Status:     ", s.ConnectionStatus)
+		fmt.Println("Last error: ", s.LastConnectionError)
+		fmt.Println("Uptime:     ", s.Uptime)
+		if ci, err := d.ConnectionType(ctx); err == nil {
+			fmt.Println("Type:       ", ci.Type)
+		}
+		if nat, err := d.NATEnabled(ctx); err == nil {
+			fmt.Println("NAT:        ", nat)
+		}
+		if info, err := d.ExternalIPInfo(ctx); err == nil {
+			fmt.Printf("External IP: %v (%v)\n", info.IP, info.Class)
+		}
+
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		proto := fs.String("proto", "", "only list mappings for this protocol")
+		desc := fs.String("desc", "", "only list mappings whose description begins with this prefix")
+		watch := fs.Bool("watch", false, "keep running, listing the mappings again whenever they change")
+		fs.Parse(args)
+		if fs.NArg() != 0 {
+			usageError("usage: upnp list [flags]")
+		}
+		f := upnp.MappingFilter{DescriptionPrefix: *desc}
+		if *proto != "" {
+			f.Protocol = parseProto(*proto)
+		}
+		if *watch {
+			watchMappings(ctx, connect(ctx), f)
+			break
+		}
+		w := newMappingWriter()
+		err := connect(ctx).WalkMappings(ctx, f, func(m upnp.PortMapping) bool {
+			writeMapping(w, m)
+			return true
+		})
+		w.Flush()
+		check("couldn't list mappings", err)
+
+	case "audit":
+		fs := flag.NewFlagSet("audit", flag.ExitOnError)
+		expiring := fs.Duration("expiring", 10*time.Minute, "report leases that expire within this duration")
+		probe := fs.Duration("probe", 0, "flag hosts that do not respond within this duration (0 to skip probing)")
+		fs.Parse(args)
+		if fs.NArg() != 0 {
+			usageError("usage: upnp audit [-expiring d] [-probe d]")
+		}
+		var opts []upnp.AuditOption
+		if *probe > 0 {
+			opts = append(opts, upnp.WithLivenessProbe(*probe))
+		}
+		r, err := connect(ctx).Audit(ctx, *expiring, opts...)
+		check("couldn't audit mappings", err)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		row := func(category string, m upnp.PortMapping, note string) {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v:%v\t%v\t%v\n", category, m.Protocol, m.ExternalPort, m.InternalClient, m.InternalPort, m.Description, note)
+		}
+		fmt.Fprintln(w, "CATEGORY\tPROTO\tEXTERNAL\tINTERNAL\tDESCRIPTION\tNOTE")
+		for _, m := range r.Suspicious {
+			row("suspicious", m.PortMapping, m.Reason)
+		}
+		for _, m := range r.ExpiringSoon {
+			row("expiring", m, "lease expires in "+m.Lease.String())
+		}
+		for _, m := range r.Disabled {
+			row("disabled", m, "")
+		}
+		for _, m := range r.Ours {
+			row("ours", m, "")
+		}
+		for _, m := range r.OtherHosts {
+			row("other", m, "")
+		}
+		w.Flush()
+		fmt.Printf("%v mappings: %v ours, %v other hosts, %v disabled, %v suspicious, %v expiring soon\n",
+			r.Total(), len(r.Ours), len(r.OtherHosts), len(r.Disabled), len(r.Suspicious), len(r.ExpiringSoon))
+
+	case "forward":
+		fs := flag.NewFlagSet("forward", flag.ExitOnError)
+		lease := fs.Duration("lease", 0, "lease duration (0 for a permanent mapping)")
+		internalPort := fs.String("internal-port", "", "internal port (defaults to the external port)")
+		client := fs.String("client", "", "internal client (defaults to this host)")
+		policy := fs.String("policy", "default", "behavior if the port is already mapped (default, skip-identical, overwrite-own, fail-fast, replace)")
+		fs.Parse(args)
+		if fs.NArg() < 2 || fs.NArg() > 3 {
+			usageError("usage: upnp forward [flags] port proto [desc]")
+		}
+		port, proto := parsePort(fs.Arg(0)), parseProto(fs.Arg(1))
+		desc := fs.Arg(2)
+		if desc == "" {
+			desc = "upnp"
+		}
+		opts := []upnp.ForwardOption{upnp.WithLease(*lease)}
+		if *internalPort != "" {
+			opts = append(opts, upnp.WithInternalPort(parsePort(*internalPort)))
+		}
+		if *client != "" {
+			opts = append(opts, upnp.WithInternalClient(*client))
+		}
+		opts = append(opts, upnp.WithPolicy(parsePolicy(*policy)))
+		check("couldn't forward port", connect(ctx).Forward(ctx, port, proto, desc, opts...))
+
+	case "clear":
+		if len(args) != 2 {
+			usageError("usage: upnp clear port proto")
+		}
+		port, proto := parsePort(args[0]), parseProto(args[1])
+		check("couldn't clear port", connect(ctx).Clear(ctx, port, proto))
+
+	case "serve":
+		serve(connect(ctx), args)
+
+	default:
+		usageError(fmt.Sprintf("unknown command %q", cmd))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"lukechampine.com/upnp"
+)
+
+// A serveConfig specifies the mappings maintained by the serve command.
+type serveConfig struct {
+	// Interval is how often the gateway's mappings are checked, e.g. "1m".
+	Interval string         `json:"interval"`
+	Mappings []serveMapping `json:"mappings"`
+}
+
+type serveMapping struct {
+	Port         uint16 `json:"port"`
+	Protocol     string `json:"protocol"`
+	Description  string `json:"description"`
+	InternalPort uint16 `json:"internalPort"`
+	Client       string `json:"client"`
+	// Lease is the lease duration of the mapping, e.g. "1h", or empty for a
+	// permanent mapping.
+	Lease string `json:"lease"`
+}
+
+func loadConfig(path string) (time.Duration, []upnp.PortMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	var cfg serveConfig
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return 0, nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	interval := time.Minute
+	if cfg.Interval != "" {
+		if interval, err = time.ParseDuration(cfg.Interval); err != nil {
+			return 0, nil, fmt.Errorf("invalid interval: %w", err)
+		} else if interval < time.Second {
+			return 0, nil, fmt.Errorf("interval must be at least 1s")
+		}
+	}
+	ms := make([]upnp.PortMapping, len(cfg.Mappings))
+	for i, m := range cfg.Mappings {
+		var lease time.Duration
+		if m.Lease != "" {
+			if lease, err = time.ParseDuration(m.Lease); err != nil {
+				return 0, nil, fmt.Errorf("mapping %v: invalid lease: %w", i, err)
+			}
+		}
+		proto, err := upnp.ParseProto(m.Protocol)
+		if m.Port == 0 {
+			return 0, nil, fmt.Errorf("mapping %v: missing port", i)
+		} else if err != nil {
+			return 0, nil, fmt.Errorf("mapping %v: %w", i, err)
+		}
+		desc := m.Description
+		if desc == "" {
+			desc = "upnp"
+		}
+		ms[i] = upnp.PortMapping{
+			ExternalPort:   m.Port,
+			InternalPort:   m.InternalPort,
+			InternalClient: m.Client,
+			Protocol:       proto,
+			Description:    desc,
+			Lease:          lease,
+		}
+	}
+	return interval, ms, nil
+}
+
+// serve maintains the mappings specified in a config file until interrupted,
+// then clears them. The mappings are reloaded from the config on SIGHUP (where
+// supported); the interval is not.
+func serve(d upnp.Device, args []string) {
+	if len(args) != 1 {
+		usageError("usage: upnp serve config.json")
+	}
+	path := args[0]
+	interval, ms, err := loadConfig(path)
+	check("couldn't load config", err)
+
+	r := upnp.NewReconciler(d, interval)
+	r.WatchInternalIP()
+	r.SetDesired(ms)
+	log.Printf("maintaining %v mappings on %v", len(ms), d.Location())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	if reloadSignal != nil {
+		signal.Notify(sigs, reloadSignal)
+	}
+	for {
+		select {
+		case e := <-r.Events():
+			m := e.Mapping
+			if e.Err != nil {
+				log.Printf("%v %v/%v: %v", e.Type, m.Protocol, m.ExternalPort, e.Err)
+			} else {
+				log.Printf("%v %v/%v", e.Type, m.Protocol, m.ExternalPort)
+			}
+		case sig := <-sigs:
+			if sig == reloadSignal {
+				_, ms, err := loadConfig(path)
+				if err != nil {
+					log.Printf("couldn't reload config: %v", err)
+					continue
+				}
+				r.SetDesired(ms)
+				log.Printf("reloaded config; maintaining %v mappings", len(ms))
+				continue
+			}
+			log.Printf("received %v; clearing mappings", sig)
+			check("couldn't clear mappings", r.Close())
+			return
+		}
+	}
+}
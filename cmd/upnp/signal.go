@@ -0,0 +1,12 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignal causes the serve command to reload its config.
+var reloadSignal os.Signal = syscall.SIGHUP
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// reloadSignal causes the serve command to reload its config. There is no
+// SIGHUP on js, so the config cannot be reloaded.
+var reloadSignal os.Signal
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"lukechampine.com/upnp"
+)
+
+func newMappingWriter() *tabwriter.Writer {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PROTO\tEXTERNAL\tINTERNAL\tENABLED\tLEASE\tDESCRIPTION")
+	return w
+}
+
+func writeMapping(w *tabwriter.Writer, m upnp.PortMapping) {
+	lease := "permanent"
+	if m.Lease > 0 {
+		lease = m.Lease.String()
+	}
+	fmt.Fprintf(w, "%v\t%v\t%v:%v\t%v\t%v\t%v\n", m.Protocol, m.ExternalPort, m.InternalClient, m.InternalPort, m.Enabled, lease, m.Description)
+}
+
+// watchMappings lists the mappings matching f, then lists them again whenever
+// the table changes, until interrupted.
+func watchMappings(ctx context.Context, d upnp.Device, f upnp.MappingFilter) {
+	// poll as well, in case the gateway doesn't send events
+	t, err := d.WatchMappings(ctx, time.Minute)
+	check("couldn't watch mappings", err)
+	defer t.Close()
+	if err := t.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't subscribe to events (%v); polling every minute\n", err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	ms := t.Mappings()
+	for {
+		fmt.Printf("%v\n", time.Now().Format(time.TimeOnly))
+		w := newMappingWriter()
+		for _, m := range ms {
+			if f.Match(m) {
+				writeMapping(w, m)
+			}
+		}
+		w.Flush()
+		fmt.Println()
+		select {
+		case ms = <-t.Changes():
+		case <-sigs:
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"lukechampine.com/upnp/internal/gateway"
+)
+
+// wellKnownDescriptions are the description URLs (sans host) used by common
+// router firmwares.
+var wellKnownDescriptions = []string{
+	":5000/rootDesc.xml", // miniupnpd
+	":49000/igddesc.xml", // AVM FRITZ!Box
+}
+
+// defaultGatewayAddr returns the SSDP address of the gateway of the OS's
+// default route.
+func defaultGatewayAddr() (*net.UDPAddr, error) {
+	gw, err := gateway.Default()
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: gw, Port: 1900}, nil
+}
+
+// DiscoverDefaultGateway locates the gateway of the OS's default route and
+// connects to it. Rather than multicasting, the SSDP search is unicast to the
+// gateway, while concurrently probing the description URLs used by common
+// firmwares. This is typically faster and more deterministic than Discover,
+// particularly on networks with many UPnP devices.
+func DiscoverDefaultGateway(ctx context.Context, opts ...Option) (Device, error) {
+	gw, err := gateway.Default()
+	if err != nil {
+		return Device{}, fmt.Errorf("couldn't determine default gateway: %w", err)
+	}
+	host := gw.String()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		d   Device
+		err error
+	}
+	results := make(chan result, 1+len(wellKnownDescriptions))
+	go func() {
+		searchOpts := append(append([]Option(nil), opts...), WithSearchAddrs(net.JoinHostPort(host, "1900")))
+		d, err := Discover(ctx, searchOpts...)
+		results <- result{d, err}
+	}()
+	for _, desc := range wellKnownDescriptions {
+		go func(url string) {
+			d, err := Connect(ctx, url, opts...)
+			results <- result{d, err}
+		}("http://" + host + desc)
+	}
+	for i := 0; i < cap(results); i++ {
+		if r := <-results; r.err == nil {
+			return r.d, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return Device{}, err
+	}
+	return Device{}, fmt.Errorf("no UPnP-enabled gateway found at %v", host)
+}
@@ -0,0 +1,43 @@
+//go:build go1.23
+
+package upnp
+
+import (
+	"context"
+	"iter"
+)
+
+// Devices returns an iterator over the Devices on the local network. Devices
+// that responded to the search but could not be used are yielded with a
+// non-nil error (see DiscoveryResult); callers that only want usable Devices
+// can skip them. Iteration ends when the search completes, the loop exits, or
+// ctx is done, in which case ctx.Err() is yielded. If the search could not be
+// started, its error is yielded and iteration ends.
+func Devices(ctx context.Context, opts ...Option) iter.Seq2[Device, error] {
+	return func(yield func(Device, error) bool) {
+		results, err := DiscoverResultsCtx(ctx, opts...)
+		if err != nil {
+			yield(Device{}, err)
+			return
+		}
+		defer func() {
+			go func() {
+				for range results {
+				}
+			}()
+		}()
+		for {
+			select {
+			case r, ok := <-results:
+				if !ok {
+					return
+				} else if !yield(r.Device, r.Err) {
+					return
+				}
+			case <-ctx.Done():
+				yield(Device{}, ctx.Err())
+				return
+			}
+		}
+	}
+}
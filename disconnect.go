@@ -0,0 +1,52 @@
+package upnp
+
+import (
+	"context"
+	"time"
+
+	"lukechampine.com/upnp/internal/goupnp"
+)
+
+// IdleDisconnectTime returns how long the router's WAN connection may remain
+// idle before the router disconnects it, or zero if it never does. Routers on
+// metered connections (e.g. PPPoE or dial-up) commonly enable this, silently
+// breaking always-on services that go quiet for a while.
+func (d Device) IdleDisconnectTime(ctx context.Context) (time.Duration, error) {
+	resp, err := d.client.GetIdleDisconnectTime(ctx)
+	return time.Duration(resp.NewIdleDisconnectTime) * time.Second, err
+}
+
+// SetIdleDisconnectTime sets how long the router's WAN connection may remain
+// idle before it is disconnected. Zero disables idle disconnects. The duration
+// is rounded up to a whole number of seconds.
+func (d Device) SetIdleDisconnectTime(ctx context.Context, t time.Duration) error {
+	return d.client.SetIdleDisconnectTime(ctx, goupnp.IdleDisconnectTime{NewIdleDisconnectTime: leaseSeconds(t)})
+}
+
+// AutoDisconnectTime returns how long after being established the router's WAN
+// connection is disconnected, regardless of activity, or zero if it never is.
+func (d Device) AutoDisconnectTime(ctx context.Context) (time.Duration, error) {
+	resp, err := d.client.GetAutoDisconnectTime(ctx)
+	return time.Duration(resp.NewAutoDisconnectTime) * time.Second, err
+}
+
+// SetAutoDisconnectTime sets how long after being established the router's
+// WAN connection is disconnected. Zero disables automatic disconnects. The
+// duration is rounded up to a whole number of seconds.
+func (d Device) SetAutoDisconnectTime(ctx context.Context, t time.Duration) error {
+	return d.client.SetAutoDisconnectTime(ctx, goupnp.AutoDisconnectTime{NewAutoDisconnectTime: leaseSeconds(t)})
+}
+
+// WarnDisconnectDelay returns how long the router warns connected users before
+// an idle or automatic disconnect.
+func (d Device) WarnDisconnectDelay(ctx context.Context) (time.Duration, error) {
+	resp, err := d.client.GetWarnDisconnectDelay(ctx)
+	return time.Duration(resp.NewWarnDisconnectDelay) * time.Second, err
+}
+
+// SetWarnDisconnectDelay sets how long the router warns connected users before
+// an idle or automatic disconnect. The duration is rounded up to a whole
+// number of seconds.
+func (d Device) SetWarnDisconnectDelay(ctx context.Context, t time.Duration) error {
+	return d.client.SetWarnDisconnectDelay(ctx, goupnp.WarnDisconnectDelay{NewWarnDisconnectDelay: leaseSeconds(t)})
+}
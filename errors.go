@@ -0,0 +1,84 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"lukechampine.com/upnp/soap"
+)
+
+// A UPnPError is an error returned by a router in response to a UPnP action.
+// Errors with the same Code are considered equivalent by errors.Is, so callers
+// can test for specific failures by comparing against the Err* values below.
+type UPnPError = soap.UPnPError
+
+// An HTTPError is an HTTP error response from a router that did not contain a
+// SOAP fault.
+type HTTPError = soap.HTTPError
+
+// IsTransient reports whether err is likely to be resolved by retrying the
+// request; see WithRetries.
+func IsTransient(err error) bool {
+	return soap.IsTransient(err)
+}
+
+// Common UPnP errors.
+var (
+	ErrInvalidAction                    = &UPnPError{Code: 401, Description: "Invalid Action"}
+	ErrInvalidArgs                      = &UPnPError{Code: 402, Description: "Invalid Args"}
+	ErrActionFailed                     = &UPnPError{Code: 501, Description: "Action Failed"}
+	ErrActionNotAuthorized              = &UPnPError{Code: 606, Description: "Action not authorized"}
+	ErrSpecifiedArrayIndexInvalid       = &UPnPError{Code: 713, Description: "SpecifiedArrayIndexInvalid"}
+	ErrNoSuchEntryInArray               = &UPnPError{Code: 714, Description: "NoSuchEntryInArray"}
+	ErrWildCardNotPermittedInSrcIP      = &UPnPError{Code: 715, Description: "WildCardNotPermittedInSrcIP"}
+	ErrWildCardNotPermittedInExtPort    = &UPnPError{Code: 716, Description: "WildCardNotPermittedInExtPort"}
+	ErrConflictInMappingEntry           = &UPnPError{Code: 718, Description: "ConflictInMappingEntry"}
+	ErrSamePortValuesRequired           = &UPnPError{Code: 724, Description: "SamePortValuesRequired"}
+	ErrOnlyPermanentLeasesSupported     = &UPnPError{Code: 725, Description: "OnlyPermanentLeasesSupported"}
+	ErrRemoteHostOnlySupportsWildcard   = &UPnPError{Code: 726, Description: "RemoteHostOnlySupportsWildcard"}
+	ErrExternalPortOnlySupportsWildcard = &UPnPError{Code: 727, Description: "ExternalPortOnlySupportsWildcard"}
+	ErrNoPortMapsAvailable              = &UPnPError{Code: 728, Description: "NoPortMapsAvailable"}
+	ErrConflictWithOtherMechanisms      = &UPnPError{Code: 729, Description: "ConflictWithOtherMechanisms"}
+)
+
+// isFault returns true if err was returned by the router, as opposed to being
+// a transport or decoding error.
+func isFault(err error) bool {
+	var ue *soap.UPnPError
+	var sf *soap.Fault
+	return errors.As(err, &ue) || errors.As(err, &sf)
+}
+
+// A ConflictError is returned when a port cannot be forwarded because it is
+// already mapped to another client.
+type ConflictError struct {
+	Port     uint16
+	Protocol string
+	// Existing is the conflicting mapping. It is the zero value if the router
+	// would not report it.
+	Existing PortMapping
+	Err      error
+}
+
+// Error implements error.
+func (e *ConflictError) Error() string {
+	if e.Existing.InternalClient == "" {
+		return fmt.Sprintf("%v port %v is already mapped: %v", e.Protocol, e.Port, e.Err)
+	}
+	return fmt.Sprintf("%v port %v is already mapped to %v:%v (%q): %v", e.Protocol, e.Port,
+		e.Existing.InternalClient, e.Existing.InternalPort, e.Existing.Description, e.Err)
+}
+
+// Unwrap returns the underlying UPnP error.
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+func (d Device) conflictError(ctx context.Context, port uint16, proto string, err error) error {
+	ce := &ConflictError{Port: port, Protocol: proto, Err: err}
+	if m, err := d.specificMapping(ctx, port, proto); err == nil {
+		ce.Existing = m
+	}
+	return ce
+}
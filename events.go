@@ -0,0 +1,195 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"lukechampine.com/upnp/internal/clock"
+	"lukechampine.com/upnp/internal/goupnp"
+	"lukechampine.com/upnp/internal/safexml"
+)
+
+// An Event is a notification that one or more of a service's evented state
+// variables have changed, e.g. ExternalIPAddress, ConnectionStatus, or
+// PortMappingNumberOfEntries.
+type Event struct {
+	// Seq is the event's sequence number. The initial event, which contains
+	// the current value of every evented variable, has Seq 0.
+	Seq uint32
+	// Variables maps the names of the changed variables to their new values.
+	Variables map[string]string
+}
+
+// A Subscription receives events from a Device.
+type Subscription struct {
+	d      Device
+	srv    *http.Server
+	events chan Event
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	sid      string
+	err      error
+	closed   bool
+	handlers sync.WaitGroup // in-flight handleNotify calls
+}
+
+// Events returns a channel that receives the subscription's events. The channel
+// is closed when the subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns the error encountered by the most recent renewal attempt, or nil
+// if it succeeded.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close cancels the subscription and stops the callback listener.
+func (s *Subscription) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("subscription already closed")
+	}
+	s.closed = true
+	sid := s.sid
+	s.mu.Unlock()
+	// unblocks any handlers waiting to send an event
+	s.cancel()
+	<-s.done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := s.d.client.Unsubscribe(ctx, sid)
+	sctx, scancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer scancel()
+	s.srv.Shutdown(sctx)
+	// Shutdown may time out, so wait for in-flight handlers explicitly before
+	// closing the channel
+	s.handlers.Wait()
+	close(s.events)
+	return err
+}
+
+func (s *Subscription) handleNotify(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		http.Error(w, "subscription closed", http.StatusPreconditionFailed)
+		return
+	}
+	s.handlers.Add(1)
+	defer s.handlers.Done()
+	sid := s.sid
+	s.mu.Unlock()
+	if req.Method != "NOTIFY" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	} else if req.Header.Get("NT") != "upnp:event" || req.Header.Get("NTS") != "upnp:propchange" {
+		http.Error(w, "invalid NT or NTS header", http.StatusBadRequest)
+		return
+	} else if sid != "" && req.Header.Get("SID") != sid {
+		// NOTE: the router may send the initial event before we've received
+		// the SID, so we accept any SID until then
+		http.Error(w, "unknown SID", http.StatusPreconditionFailed)
+		return
+	}
+	seq, _ := strconv.ParseUint(req.Header.Get("SEQ"), 10, 32)
+	body, err := safexml.Read(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	vars, err := goupnp.ParseEvent(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	select {
+	case s.events <- Event{Seq: uint32(seq), Variables: vars}:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *Subscription) renewLoop(requested, timeout time.Duration) {
+	defer close(s.done)
+	wait := timeout / 2
+	for {
+		if !clock.Sleep(s.d.client.Clock(), wait, s.ctx.Done()) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+		s.mu.Lock()
+		sid := s.sid
+		s.mu.Unlock()
+		t, err := s.d.client.RenewSubscription(ctx, sid, requested)
+		if err != nil {
+			// the router may have forgotten the subscription (e.g. after a
+			// reboot); try subscribing again
+			var newSID string
+			newSID, t, err = s.d.client.Subscribe(ctx, "http://"+s.srv.Addr+"/", requested)
+			if err == nil {
+				s.mu.Lock()
+				s.sid = newSID
+				s.mu.Unlock()
+			}
+		}
+		cancel()
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		if err != nil {
+			wait = 30 * time.Second
+		} else if t > 0 {
+			wait = t / 2
+		} else {
+			wait = requested / 2
+		}
+	}
+}
+
+// Subscribe subscribes to the Device's events. A callback HTTP server is started
+// on the Device's internal IP to receive them, and the subscription is renewed
+// in the background until Close is called.
+func (d Device) Subscribe(ctx context.Context) (*Subscription, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(d.internalIP, "0"))
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscription{
+		d:      d,
+		events: make(chan Event),
+		done:   make(chan struct{}),
+	}
+	s.srv = &http.Server{Addr: l.Addr().String(), Handler: http.HandlerFunc(s.handleNotify)}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.srv.Serve(l)
+
+	const requestedTimeout = 30 * time.Minute
+	sid, timeout, err := d.client.Subscribe(ctx, "http://"+s.srv.Addr+"/", requestedTimeout)
+	if err != nil {
+		s.cancel()
+		s.srv.Close()
+		return nil, fmt.Errorf("couldn't subscribe: %w", err)
+	}
+	s.mu.Lock()
+	s.sid = sid
+	s.mu.Unlock()
+	if timeout == 0 {
+		timeout = requestedTimeout
+	}
+	go s.renewLoop(requestedTimeout, timeout)
+	return s, nil
+}
@@ -0,0 +1,85 @@
+package upnp
+
+import (
+	"context"
+	"strings"
+)
+
+// A ServiceEndpoint describes one of the services offered by a router, which
+// may belong to the root device or to one of its embedded devices.
+type ServiceEndpoint struct {
+	DeviceUDN   string
+	ServiceType string
+	ServiceID   string
+	ControlURL  string
+	EventSubURL string
+	SCPDURL     string
+}
+
+// Services returns every service offered by the router, including
+// vendor-specific ones. URLs are absolute.
+func (d Device) Services(ctx context.Context) ([]ServiceEndpoint, error) {
+	clients, err := d.client.Siblings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]ServiceEndpoint, len(clients))
+	for i, c := range clients {
+		s := c.State()
+		endpoints[i] = ServiceEndpoint{
+			DeviceUDN:   c.DeviceUDN(),
+			ServiceType: s.Service.ServiceType,
+			ServiceID:   strings.TrimSpace(s.Service.ServiceID),
+			ControlURL:  s.ControlURL,
+			EventSubURL: s.EventSubURL,
+			SCPDURL:     s.SCPDURL,
+		}
+	}
+	return endpoints, nil
+}
+
+// An Action is a typed UPnP action, allowing packages outside this one to
+// define vendor-specific actions (or standard actions not otherwise supported)
+// once and invoke them on any Device. Req and Resp are marshaled and
+// unmarshaled as described by Device.Call. For example:
+//
+//	var getInfo = upnp.Action[struct{}, struct{ NewModelName string }]{
+//		ServiceType: "urn:dslforum-org:service:DeviceInfo:1",
+//		Name:        "GetInfo",
+//	}
+//	info, err := getInfo.Call(ctx, d, struct{}{})
+type Action[Req, Resp interface{}] struct {
+	// ServiceType is the type of the service offering the action, which also
+	// serves as the action's namespace. If empty, the Device's WAN connection
+	// service is used.
+	ServiceType string
+	Name        string
+}
+
+// Call invokes the action on d.
+func (a Action[Req, Resp]) Call(ctx context.Context, d Device, req Req) (Resp, error) {
+	var resp Resp
+	err := d.Call(ctx, a.ServiceType, a.Name, req, &resp)
+	return resp, err
+}
+
+// Supported reports whether d offers the action's service, and whether that
+// service's description declares the action.
+func (a Action[Req, Resp]) Supported(ctx context.Context, d Device) (bool, error) {
+	c := d.client
+	if a.ServiceType != "" && a.ServiceType != c.ServiceType() {
+		clients, err := d.client.Siblings(ctx, a.ServiceType)
+		if err != nil {
+			return false, err
+		} else if len(clients) == 0 {
+			return false, nil
+		}
+		c = clients[0]
+	}
+	scpd, err := c.SCPD(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := scpd.Action(a.Name)
+	return ok, nil
+}
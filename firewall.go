@@ -0,0 +1,144 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"lukechampine.com/upnp/igd"
+	"lukechampine.com/upnp/internal/goupnp"
+)
+
+// A Firewall can open inbound IPv6 pinholes on a router that supports the
+// WANIPv6FirewallControl service.
+type Firewall struct {
+	internalIP string
+	client     goupnp.IGDClient
+}
+
+// FirewallStatus describes the state of a router's IPv6 firewall.
+type FirewallStatus struct {
+	Enabled               bool
+	InboundPinholeAllowed bool
+}
+
+// Firewall returns the IPv6 firewall of the device. Pinholes opened via the
+// Firewall point at a global IPv6 address on the same interface as the Device's
+// internal IP.
+func (d Device) Firewall(ctx context.Context) (Firewall, error) {
+	c, err := d.serviceClient(ctx, igd.WANIPv6FirewallControl1)
+	if err != nil {
+		return Firewall{}, err
+	}
+	ip, err := getInternalIPv6(d.internalIP)
+	if err != nil {
+		return Firewall{}, err
+	}
+	return Firewall{ip, c}, nil
+}
+
+// getInternalIPv6 returns a global unicast IPv6 address on the same interface
+// as the specified IPv4 address.
+func getInternalIPv6(ipv4 string) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return "", err
+		}
+		var found bool
+		var v6 net.IP
+		for _, addr := range addrs {
+			x, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			} else if x.IP.String() == ipv4 {
+				found = true
+			} else if x.IP.To4() == nil && x.IP.IsGlobalUnicast() && v6 == nil {
+				v6 = x.IP
+			}
+		}
+		if found {
+			if v6 == nil {
+				return "", fmt.Errorf("no global IPv6 address on interface %v", iface.Name)
+			}
+			return v6.String(), nil
+		}
+	}
+	return "", fmt.Errorf("could not find interface with address %v", ipv4)
+}
+
+// ianaProto returns the IANA protocol number for proto.
+func ianaProto(proto string) (uint16, error) {
+	p, err := ParseProto(proto)
+	if err != nil {
+		return 0, err
+	} else if p == TCP {
+		return 6, nil
+	}
+	return 17, nil
+}
+
+// Status returns the status of the firewall.
+func (f Firewall) Status(ctx context.Context) (FirewallStatus, error) {
+	resp, err := f.client.GetFirewallStatus(ctx)
+	return FirewallStatus{
+		Enabled:               resp.FirewallEnabled,
+		InboundPinholeAllowed: resp.InboundPinholeAllowed,
+	}, err
+}
+
+// AddPinhole opens a pinhole allowing inbound traffic from any remote host to
+// the specified port on this host, returning the ID assigned to the pinhole by
+// the router. The lease must be between 1 second and 24 hours; pinholes can be
+// kept open longer via UpdatePinhole.
+func (f Firewall) AddPinhole(ctx context.Context, port uint16, proto string, lease time.Duration) (uint16, error) {
+	pn, err := ianaProto(proto)
+	if err != nil {
+		return 0, err
+	} else if lease <= 0 || lease > 24*time.Hour {
+		return 0, errors.New("pinhole lease must be between 1 second and 24 hours")
+	}
+	resp, err := f.client.AddPinhole(ctx, goupnp.AddPinholeRequest{
+		RemoteHost:     "",
+		RemotePort:     0,
+		InternalClient: f.internalIP,
+		InternalPort:   port,
+		Protocol:       pn,
+		LeaseTime:      leaseSeconds(lease),
+	})
+	return resp.UniqueID, err
+}
+
+// UpdatePinhole extends the lease of the specified pinhole.
+func (f Firewall) UpdatePinhole(ctx context.Context, id uint16, lease time.Duration) error {
+	if lease <= 0 || lease > 24*time.Hour {
+		return errors.New("pinhole lease must be between 1 second and 24 hours")
+	}
+	return f.client.UpdatePinhole(ctx, goupnp.UpdatePinholeRequest{
+		UniqueID:     id,
+		NewLeaseTime: leaseSeconds(lease),
+	})
+}
+
+// DeletePinhole closes the specified pinhole.
+func (f Firewall) DeletePinhole(ctx context.Context, id uint16) error {
+	return f.client.DeletePinhole(ctx, goupnp.PinholeRequest{UniqueID: id})
+}
+
+// CheckPinholeWorking reports whether traffic is flowing through the specified
+// pinhole.
+func (f Firewall) CheckPinholeWorking(ctx context.Context, id uint16) (bool, error) {
+	resp, err := f.client.CheckPinholeWorking(ctx, goupnp.PinholeRequest{UniqueID: id})
+	return resp.IsWorking, err
+}
+
+// InternalIP returns the IPv6 address that pinholes point at.
+func (f Firewall) InternalIP() string {
+	return f.internalIP
+}
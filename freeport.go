@@ -0,0 +1,59 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// FindFreePort returns an external port that is not mapped for proto,
+// preferring the specified port and otherwise the nearest port above it,
+// wrapping around to 1024 (or starting there, if preferred is 0). It is
+// intended for applications that need some reachable port rather than a
+// particular one.
+//
+// The router's mapping table is walked to find candidates, and each candidate
+// is confirmed individually, since some routers cannot enumerate their table
+// reliably. Nothing is reserved, so another client may claim the port before
+// it is forwarded; ForwardAny finds and maps a port in one step.
+func (d Device) FindFreePort(ctx context.Context, proto string, preferred uint16) (uint16, error) {
+	proto, err := ParseProto(proto)
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[uint16]bool)
+	err = d.WalkMappings(ctx, MappingFilter{Protocol: proto}, func(m PortMapping) bool {
+		used[m.ExternalPort] = true
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("couldn't list mappings: %w", err)
+	}
+
+	const minPort = 1024
+	const maxProbes = 16
+	port, probes := preferred, 0
+	if port == 0 {
+		port = minPort
+	}
+	for i := 0; i < 65536 && probes < maxProbes; i++ {
+		if !used[port] {
+			_, err := d.specificMapping(ctx, port, proto)
+			if errors.Is(err, ErrNoSuchEntryInArray) || (err != nil && isFault(err)) {
+				// either confirmed free, or the router can't say; trust the
+				// table in the latter case
+				return port, nil
+			} else if err != nil {
+				return 0, err
+			}
+			// mapped, but missing from the table
+			probes++
+		}
+		if port == 65535 {
+			port = minPort
+		} else {
+			port++
+		}
+	}
+	return 0, fmt.Errorf("could not find a free %v port near %v", proto, preferred)
+}
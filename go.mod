@@ -1,3 +1,3 @@
 module lukechampine.com/upnp
 
-go 1.17
+go 1.21
@@ -0,0 +1,149 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// A MappingGroup forwards ports on a Device and remembers each mapping it
+// creates, so that they can all be cleared with a single call to Close. It is
+// intended to be deferred in main, so that a process does not leave stale
+// mappings behind when it exits.
+type MappingGroup struct {
+	d Device
+
+	mu     sync.Mutex
+	keys   map[mappingKey]struct{}
+	closed bool
+}
+
+var _ Forwarder = (*MappingGroup)(nil)
+
+// NewMappingGroup returns an empty MappingGroup for the Device.
+func NewMappingGroup(d Device) *MappingGroup {
+	return &MappingGroup{
+		d:    d,
+		keys: make(map[mappingKey]struct{}),
+	}
+}
+
+// track adds a mapping to the group, reporting whether it was already present.
+func (g *MappingGroup) track(port uint16, proto string) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false, errors.New("mapping group closed")
+	}
+	key := keyFor(port, proto)
+	_, ok := g.keys[key]
+	g.keys[key] = struct{}{}
+	return ok, nil
+}
+
+func (g *MappingGroup) untrack(port uint16, proto string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.keys, keyFor(port, proto))
+}
+
+// Forward is like Device.Forward, but records the mapping in the group. If the
+// port was already mapped on the router (by another process, or an earlier run
+// of this one), the mapping is updated but not recorded, so Close leaves it in
+// place.
+func (g *MappingGroup) Forward(ctx context.Context, port uint16, proto string, desc string, opts ...ForwardOption) error {
+	g.mu.Lock()
+	_, tracked := g.keys[keyFor(port, proto)]
+	closed := g.closed
+	g.mu.Unlock()
+	if closed {
+		return errors.New("mapping group closed")
+	} else if !tracked {
+		// if we can't tell whether the mapping already exists, assume it
+		// does, so that we never clear a mapping we didn't create
+		if s, err := g.d.MappingStatus(ctx, port, proto); err != nil || s.Exists {
+			return g.d.Forward(ctx, port, proto, desc, opts...)
+		}
+	}
+	// track before forwarding, so that a concurrent Close can't miss the
+	// mapping
+	existed, err := g.track(port, proto)
+	if err != nil {
+		return err
+	}
+	err = g.d.Forward(ctx, port, proto, desc, opts...)
+	if err != nil && !existed {
+		// don't clear a mapping we didn't create, e.g. on conflict
+		g.untrack(port, proto)
+	}
+	return err
+}
+
+// ForwardAny is like Device.ForwardAny, but records the mapping in the group.
+func (g *MappingGroup) ForwardAny(ctx context.Context, port uint16, proto string, desc string, opts ...ForwardOption) (uint16, error) {
+	g.mu.Lock()
+	closed := g.closed
+	g.mu.Unlock()
+	if closed {
+		return 0, errors.New("mapping group closed")
+	}
+	ext, err := g.d.ForwardAny(ctx, port, proto, desc, opts...)
+	if err != nil {
+		return 0, err
+	} else if _, err := g.track(ext, proto); err != nil {
+		// the group was closed while we were forwarding
+		g.d.Clear(ctx, ext, proto)
+		return 0, err
+	}
+	return ext, nil
+}
+
+// Clear clears the specified mapping and removes it from the group.
+func (g *MappingGroup) Clear(ctx context.Context, port uint16, proto string) error {
+	if err := g.d.Clear(ctx, port, proto); err != nil {
+		return err
+	}
+	g.untrack(port, proto)
+	return nil
+}
+
+// IsForwarded implements Forwarder.
+func (g *MappingGroup) IsForwarded(ctx context.Context, port uint16, proto string) bool {
+	return g.d.IsForwarded(ctx, port, proto)
+}
+
+// ExternalIP implements Forwarder.
+func (g *MappingGroup) ExternalIP(ctx context.Context) (string, error) {
+	return g.d.ExternalIP(ctx)
+}
+
+// Close clears every mapping in the group. Subsequent attempts to forward
+// ports via the group will fail.
+func (g *MappingGroup) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return g.CloseContext(ctx)
+}
+
+// CloseContext is like Close, but uses the provided context when clearing
+// mappings.
+func (g *MappingGroup) CloseContext(ctx context.Context) error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return errors.New("mapping group already closed")
+	}
+	g.closed = true
+	keys := g.keys
+	g.keys = nil
+	g.mu.Unlock()
+
+	var firstErr error
+	for key := range keys {
+		if err := g.d.Clear(ctx, key.port, key.proto); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,167 @@
+// Package igd fetches and parses UPnP device descriptions, and defines the
+// service types of an Internet Gateway Device.
+package igd
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"lukechampine.com/upnp/internal/safexml"
+)
+
+// IGD service types.
+const (
+	WANIPConnection1          = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	WANIPConnection2          = "urn:schemas-upnp-org:service:WANIPConnection:2"
+	WANPPPConnection1         = "urn:schemas-upnp-org:service:WANPPPConnection:1"
+	WANPPPConnection2         = "urn:schemas-upnp-org:service:WANPPPConnection:2"
+	WANCommonInterfaceConfig1 = "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1"
+	WANIPv6FirewallControl1   = "urn:schemas-upnp-org:service:WANIPv6FirewallControl:1"
+	Layer3Forwarding1         = "urn:schemas-upnp-org:service:Layer3Forwarding:1"
+	DeviceProtection1         = "urn:schemas-upnp-org:service:DeviceProtection:1"
+)
+
+// ServiceVersion returns the version of a service type, e.g. 2 for
+// WANIPConnection2, or 0 if the type does not end in a version number.
+func ServiceVersion(serviceType string) int {
+	serviceType = strings.TrimSpace(serviceType)
+	v, err := strconv.Atoi(serviceType[strings.LastIndexByte(serviceType, ':')+1:])
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
+
+// Implements reports whether a service of type serviceType can be used as a
+// service of type want. Per the UPnP spec, each version of a service is a
+// superset of the previous versions, so e.g. a WANIPConnection:2 service
+// implements WANIPConnection1, but not vice versa.
+func Implements(serviceType, want string) bool {
+	serviceType = strings.TrimSpace(serviceType)
+	if serviceType == want {
+		return true
+	}
+	have, min := ServiceVersion(serviceType), ServiceVersion(want)
+	i, j := strings.LastIndexByte(serviceType, ':'), strings.LastIndexByte(want, ':')
+	return min > 0 && have >= min && i >= 0 && j >= 0 && serviceType[:i] == want[:j]
+}
+
+// A Service is a service offered by a device.
+type Service struct {
+	ServiceType string `xml:"serviceType"`
+	// ServiceID identifies the service within its device, e.g.
+	// "urn:upnp-org:serviceId:WANIPConn1".
+	ServiceID string `xml:"serviceId"`
+	// The service's URLs may be relative; use RootDevice.ResolveURL to
+	// resolve them.
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+// A Device is a device or embedded device, along with its services and embedded
+// devices.
+type Device struct {
+	DeviceType   string `xml:"deviceType"`
+	FriendlyName string `xml:"friendlyName"`
+	Manufacturer string `xml:"manufacturer"`
+	ModelName    string `xml:"modelName"`
+	ModelNumber  string `xml:"modelNumber"`
+	SerialNumber string `xml:"serialNumber"`
+	// PresentationURL is the URL of the device's web interface, if any.
+	PresentationURL string `xml:"presentationURL"`
+	// UDN is the device's Unique Device Name, e.g.
+	// "uuid:75802409-bccb-40e7-8e6c-fa095ecce13e".
+	UDN      string    `xml:"UDN"`
+	Services []Service `xml:"serviceList>service,omitempty"`
+	Devices  []Device  `xml:"deviceList>device,omitempty"`
+}
+
+// A RootDevice is a parsed device description.
+type RootDevice struct {
+	XMLName xml.Name `xml:"root"`
+	URLBase string   `xml:"URLBase"`
+	Device  Device   `xml:"device"`
+	// Location is the URL the description was fetched from.
+	Location string `xml:"-"`
+	// Server is the SERVER header sent with the description, which typically
+	// identifies the device's OS and UPnP stack.
+	Server string `xml:"-"`
+}
+
+// ResolveURL resolves a URL found in the description, such as a service's
+// ControlURL, relative to the description's URLBase. Per the UPnP spec, if no
+// URLBase is specified, the URL the description was fetched from is used
+// instead.
+func (rd RootDevice) ResolveURL(ref string) (string, error) {
+	base := strings.TrimSpace(rd.URLBase)
+	if base == "" {
+		base = rd.Location
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	refURL, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// FindServices returns every service of the device or its embedded devices
+// that implements one of serviceTypes.
+func (d Device) FindServices(serviceTypes ...string) []Service {
+	var srvs []Service
+	for _, srv := range d.Services {
+		for _, st := range serviceTypes {
+			if Implements(srv.ServiceType, st) {
+				srvs = append(srvs, srv)
+				break
+			}
+		}
+	}
+	for _, d := range d.Devices {
+		srvs = append(srvs, d.FindServices(serviceTypes...)...)
+	}
+	return srvs
+}
+
+// DeviceByURL fetches and parses the device description at the specified URL,
+// typically the LOCATION of an SSDP response.
+func DeviceByURL(ctx context.Context, client *http.Client, loc string) (RootDevice, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", loc, nil)
+	if err != nil {
+		return RootDevice{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return RootDevice{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return RootDevice{}, fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var root RootDevice
+	dec, err := safexml.NewDecoder(resp.Body)
+	if err != nil {
+		return RootDevice{}, fmt.Errorf("invalid response body: %w", err)
+	}
+	dec.DefaultSpace = "urn:schemas-upnp-org:device-1-0"
+	if err := dec.Decode(&root); err != nil {
+		return RootDevice{}, fmt.Errorf("invalid response body: %w", err)
+	}
+	root.Location = loc
+	root.Server = resp.Header.Get("SERVER")
+	return root, nil
+}
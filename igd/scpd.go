@@ -0,0 +1,117 @@
+package igd
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"lukechampine.com/upnp/internal/safexml"
+)
+
+// An SCPD is a parsed service description, listing the actions and state
+// variables that a service supports.
+type SCPD struct {
+	XMLName        xml.Name        `xml:"scpd"`
+	Actions        []Action        `xml:"actionList>action"`
+	StateVariables []StateVariable `xml:"serviceStateTable>stateVariable"`
+}
+
+// An Action is an action declared by a service.
+type Action struct {
+	Name      string     `xml:"name"`
+	Arguments []Argument `xml:"argumentList>argument"`
+}
+
+// An Argument is an input or output argument of an Action.
+type Argument struct {
+	Name string `xml:"name"`
+	// Direction is either "in" or "out".
+	Direction            string `xml:"direction"`
+	RelatedStateVariable string `xml:"relatedStateVariable"`
+}
+
+// A StateVariable is a state variable declared by a service.
+type StateVariable struct {
+	Name          string   `xml:"name"`
+	DataType      string   `xml:"dataType"`
+	DefaultValue  string   `xml:"defaultValue"`
+	AllowedValues []string `xml:"allowedValueList>allowedValue"`
+	// SendEvents is "yes" if changes to the variable are evented.
+	SendEvents string `xml:"sendEvents,attr"`
+}
+
+// Action returns the action with the specified name.
+func (s SCPD) Action(name string) (Action, bool) {
+	for _, a := range s.Actions {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Action{}, false
+}
+
+// StateVariable returns the state variable with the specified name.
+func (s SCPD) StateVariable(name string) (StateVariable, bool) {
+	for _, v := range s.StateVariables {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return StateVariable{}, false
+}
+
+// Inputs returns the names of the action's input arguments, in the order in
+// which they must be sent.
+func (a Action) Inputs() []string {
+	return a.names("in")
+}
+
+// Outputs returns the names of the action's output arguments, in the order in
+// which they are returned.
+func (a Action) Outputs() []string {
+	return a.names("out")
+}
+
+func (a Action) names(dir string) []string {
+	var names []string
+	for _, arg := range a.Arguments {
+		if strings.EqualFold(strings.TrimSpace(arg.Direction), dir) {
+			names = append(names, arg.Name)
+		}
+	}
+	return names
+}
+
+// SCPDByURL fetches and parses the service description at the specified URL,
+// typically a service's SCPDURL resolved via RootDevice.ResolveURL.
+func SCPDByURL(ctx context.Context, client *http.Client, loc string) (SCPD, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", loc, nil)
+	if err != nil {
+		return SCPD{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return SCPD{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		resp, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return SCPD{}, errors.New(string(resp))
+	}
+
+	var scpd SCPD
+	dec, err := safexml.NewDecoder(resp.Body)
+	if err != nil {
+		return SCPD{}, fmt.Errorf("invalid response body: %w", err)
+	}
+	dec.DefaultSpace = "urn:schemas-upnp-org:service-1-0"
+	if err := dec.Decode(&scpd); err != nil {
+		return SCPD{}, fmt.Errorf("invalid response body: %w", err)
+	}
+	return scpd, nil
+}
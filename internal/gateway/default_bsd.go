@@ -0,0 +1,46 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package gateway
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Default returns the IPv4 gateway of the default route, as reported by the
+// kernel's routing table.
+func Default() (net.IP, error) {
+	rib, err := syscall.RouteRIB(syscall.NET_RT_DUMP, 0)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseRoutingMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		m, ok := msg.(*syscall.RouteMessage)
+		if !ok || m.Header.Flags&syscall.RTF_UP == 0 || m.Header.Flags&syscall.RTF_GATEWAY == 0 {
+			continue
+		}
+		addrs, err := syscall.ParseRoutingSockaddr(m)
+		if err != nil || len(addrs) <= syscall.RTAX_GATEWAY {
+			continue
+		}
+		dst, ok := addrs[syscall.RTAX_DST].(*syscall.SockaddrInet4)
+		if !ok || dst.Addr != [4]byte{} {
+			continue
+		}
+		if len(addrs) > syscall.RTAX_NETMASK {
+			if mask, ok := addrs[syscall.RTAX_NETMASK].(*syscall.SockaddrInet4); ok && mask.Addr != [4]byte{} {
+				continue
+			}
+		}
+		if gw, ok := addrs[syscall.RTAX_GATEWAY].(*syscall.SockaddrInet4); ok {
+			return net.IPv4(gw.Addr[0], gw.Addr[1], gw.Addr[2], gw.Addr[3]), nil
+		}
+	}
+	return nil, errors.New("no default route")
+}
@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Default returns the IPv4 gateway of the default route, as reported by the
+// kernel via netlink.
+func Default() (net.IP, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, syscall.AF_INET)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var gw net.IP
+	var bestPriority uint32
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type == syscall.NLMSG_DONE {
+			break
+		} else if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if rt.Dst_len != 0 || rt.Table != syscall.RT_TABLE_MAIN {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			continue
+		}
+		var ip net.IP
+		var priority uint32
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.RTA_GATEWAY:
+				if len(a.Value) == net.IPv4len {
+					ip = net.IP(append([]byte(nil), a.Value...))
+				}
+			case syscall.RTA_PRIORITY:
+				if len(a.Value) == 4 {
+					// netlink attributes are in native byte order
+					priority = *(*uint32)(unsafe.Pointer(&a.Value[0]))
+				}
+			}
+		}
+		// prefer the route with the lowest metric
+		if ip != nil && (gw == nil || priority < bestPriority) {
+			gw, bestPriority = ip, priority
+		}
+	}
+	if gw == nil {
+		return nil, errors.New("no default route")
+	}
+	return gw, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!windows,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package gateway
+
+import (
+	"errors"
+	"net"
+)
+
+// Default returns the IPv4 gateway of the default route. It is not supported
+// on this platform.
+func Default() (net.IP, error) {
+	return nil, errors.New("default gateway lookup not supported on this platform")
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+var procGetIpForwardTable = syscall.NewLazyDLL("iphlpapi.dll").NewProc("GetIpForwardTable")
+
+// sizeofIPForwardRow is the size of a MIB_IPFORWARDROW, which consists of 14
+// DWORDs.
+const sizeofIPForwardRow = 14 * 4
+
+// Default returns the IPv4 gateway of the default route, as reported by
+// GetIpForwardTable.
+func Default() (net.IP, error) {
+	var size uint32
+	r, _, _ := procGetIpForwardTable.Call(0, uintptr(unsafe.Pointer(&size)), 0)
+	if r != uintptr(syscall.ERROR_INSUFFICIENT_BUFFER) {
+		return nil, syscall.Errno(r)
+	}
+	buf := make([]byte, size)
+	r, _, _ = procGetIpForwardTable.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+	if r != 0 {
+		return nil, syscall.Errno(r)
+	}
+
+	// the table is a DWORD count followed by the rows; addresses are stored
+	// in network byte order, and everything else in native (little-endian)
+	// order
+	n := int(binary.LittleEndian.Uint32(buf))
+	var gw net.IP
+	var bestMetric uint32
+	for i := 0; i < n && 4+(i+1)*sizeofIPForwardRow <= len(buf); i++ {
+		row := buf[4+i*sizeofIPForwardRow:][:sizeofIPForwardRow]
+		dest, mask, nextHop := row[0:4], row[4:8], row[12:16]
+		metric := binary.LittleEndian.Uint32(row[36:40])
+		if binary.LittleEndian.Uint32(dest) != 0 || binary.LittleEndian.Uint32(mask) != 0 {
+			continue
+		}
+		if gw == nil || metric < bestMetric {
+			gw, bestMetric = net.IPv4(nextHop[0], nextHop[1], nextHop[2], nextHop[3]), metric
+		}
+	}
+	if gw == nil {
+		return nil, errors.New("no default route")
+	}
+	return gw, nil
+}
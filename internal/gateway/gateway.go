@@ -0,0 +1,42 @@
+// Package gateway locates candidate gateway addresses.
+package gateway
+
+import (
+	"net"
+)
+
+// Candidates returns the gateway of the default route, if known, followed by
+// the first host address of each IPv4 subnet that this host is connected to,
+// which is where the gateway usually lives.
+func Candidates() ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var gws []net.IP
+	def, err := Default()
+	if err == nil {
+		gws = append(gws, def)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			x, ok := addr.(*net.IPNet)
+			if !ok || x.IP.To4() == nil {
+				continue
+			}
+			gw := x.IP.To4().Mask(x.Mask)
+			gw[3]++
+			if !gw.Equal(x.IP.To4()) && !gw.Equal(def) {
+				gws = append(gws, gw)
+			}
+		}
+	}
+	return gws, nil
+}
@@ -0,0 +1,44 @@
+package goupnp
+
+import (
+	"context"
+)
+
+type IdleDisconnectTime struct {
+	NewIdleDisconnectTime uint32
+}
+
+type AutoDisconnectTime struct {
+	NewAutoDisconnectTime uint32
+}
+
+type WarnDisconnectDelay struct {
+	NewWarnDisconnectDelay uint32
+}
+
+func (c IGDClient) GetIdleDisconnectTime(ctx context.Context) (resp IdleDisconnectTime, err error) {
+	err = c.performAction(ctx, "GetIdleDisconnectTime", nil, &resp)
+	return
+}
+
+func (c IGDClient) SetIdleDisconnectTime(ctx context.Context, req IdleDisconnectTime) error {
+	return c.performAction(ctx, "SetIdleDisconnectTime", req, nil)
+}
+
+func (c IGDClient) GetAutoDisconnectTime(ctx context.Context) (resp AutoDisconnectTime, err error) {
+	err = c.performAction(ctx, "GetAutoDisconnectTime", nil, &resp)
+	return
+}
+
+func (c IGDClient) SetAutoDisconnectTime(ctx context.Context, req AutoDisconnectTime) error {
+	return c.performAction(ctx, "SetAutoDisconnectTime", req, nil)
+}
+
+func (c IGDClient) GetWarnDisconnectDelay(ctx context.Context) (resp WarnDisconnectDelay, err error) {
+	err = c.performAction(ctx, "GetWarnDisconnectDelay", nil, &resp)
+	return
+}
+
+func (c IGDClient) SetWarnDisconnectDelay(ctx context.Context, req WarnDisconnectDelay) error {
+	return c.performAction(ctx, "SetWarnDisconnectDelay", req, nil)
+}
@@ -0,0 +1,59 @@
+package goupnp
+
+import (
+	"context"
+)
+
+type GetFirewallStatusResponse struct {
+	FirewallEnabled       bool
+	InboundPinholeAllowed bool
+}
+
+type AddPinholeRequest struct {
+	RemoteHost     string
+	RemotePort     uint16
+	InternalClient string
+	InternalPort   uint16
+	Protocol       uint16
+	LeaseTime      uint32
+}
+
+type AddPinholeResponse struct {
+	UniqueID uint16
+}
+
+type UpdatePinholeRequest struct {
+	UniqueID     uint16
+	NewLeaseTime uint32
+}
+
+type PinholeRequest struct {
+	UniqueID uint16
+}
+
+type CheckPinholeWorkingResponse struct {
+	IsWorking bool
+}
+
+func (c IGDClient) GetFirewallStatus(ctx context.Context) (resp GetFirewallStatusResponse, err error) {
+	err = c.performAction(ctx, "GetFirewallStatus", nil, &resp)
+	return
+}
+
+func (c IGDClient) AddPinhole(ctx context.Context, req AddPinholeRequest) (resp AddPinholeResponse, err error) {
+	err = c.performAction(ctx, "AddPinhole", req, &resp)
+	return
+}
+
+func (c IGDClient) UpdatePinhole(ctx context.Context, req UpdatePinholeRequest) error {
+	return c.performAction(ctx, "UpdatePinhole", req, nil)
+}
+
+func (c IGDClient) DeletePinhole(ctx context.Context, req PinholeRequest) error {
+	return c.performAction(ctx, "DeletePinhole", req, nil)
+}
+
+func (c IGDClient) CheckPinholeWorking(ctx context.Context, req PinholeRequest) (resp CheckPinholeWorkingResponse, err error) {
+	err = c.performAction(ctx, "CheckPinholeWorking", req, &resp)
+	return
+}
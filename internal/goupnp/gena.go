@@ -0,0 +1,100 @@
+package goupnp
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lukechampine.com/upnp/internal/safexml"
+)
+
+func parseTimeout(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if len(s) > 7 && strings.EqualFold(s[:7], "Second-") {
+		if secs, err := strconv.Atoi(s[7:]); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return 0
+}
+
+func genaRequest(ctx context.Context, client *http.Client, method, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(ioutil.Discard, io.LimitReader(resp.Body, safexml.MaxSize))
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%v failed: %v", method, resp.Status)
+	}
+	return resp, nil
+}
+
+func (c IGDClient) Subscribe(ctx context.Context, callback string, timeout time.Duration) (sid string, actual time.Duration, err error) {
+	resp, err := genaRequest(ctx, c.httpClient, "SUBSCRIBE", c.eventSubURL, http.Header{
+		"CALLBACK": {"<" + callback + ">"},
+		"NT":       {"upnp:event"},
+		"TIMEOUT":  {fmt.Sprintf("Second-%d", int(timeout.Seconds()))},
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	sid = resp.Header.Get("SID")
+	if sid == "" {
+		return "", 0, fmt.Errorf("SUBSCRIBE response missing SID")
+	}
+	return sid, parseTimeout(resp.Header.Get("TIMEOUT")), nil
+}
+
+func (c IGDClient) RenewSubscription(ctx context.Context, sid string, timeout time.Duration) (time.Duration, error) {
+	resp, err := genaRequest(ctx, c.httpClient, "SUBSCRIBE", c.eventSubURL, http.Header{
+		"SID":     {sid},
+		"TIMEOUT": {fmt.Sprintf("Second-%d", int(timeout.Seconds()))},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return parseTimeout(resp.Header.Get("TIMEOUT")), nil
+}
+
+func (c IGDClient) Unsubscribe(ctx context.Context, sid string) error {
+	_, err := genaRequest(ctx, c.httpClient, "UNSUBSCRIBE", c.eventSubURL, http.Header{
+		"SID": {sid},
+	})
+	return err
+}
+
+type propertySet struct {
+	Properties []struct {
+		Variables []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"property"`
+}
+
+func ParseEvent(body []byte) (map[string]string, error) {
+	var ps propertySet
+	if err := safexml.Unmarshal(body, &ps); err != nil {
+		return nil, fmt.Errorf("invalid event body: %w", err)
+	}
+	vars := make(map[string]string)
+	for _, p := range ps.Properties {
+		for _, v := range p.Variables {
+			vars[v.XMLName.Local] = v.Value
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,43 @@
+package goupnp
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// newHTTPClient returns a client tuned for talking to devices on the LAN. Since
+// the transport is shared, connections to each device are reused across
+// Devices and requests, sparing renewal-heavy workloads a TCP (and possibly
+// TLS) handshake per action.
+func newHTTPClient(proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: proxy,
+			// devices are nearby, so a device that doesn't accept a
+			// connection promptly probably never will
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   8,
+			// routers tend to close idle connections quickly; discarding
+			// them first avoids sending requests on dead connections
+			IdleConnTimeout:       30 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
+// DirectHTTPClient dials devices directly, ignoring any HTTP proxy configured
+// in the environment. Devices are almost always on the LAN, so routing
+// requests to them through a proxy is rarely desirable.
+var DirectHTTPClient = newHTTPClient(nil)
+
+// ProxyHTTPClient honors the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment
+// variables.
+var ProxyHTTPClient = newHTTPClient(http.ProxyFromEnvironment)
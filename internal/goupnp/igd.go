@@ -2,6 +2,17 @@ package goupnp
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"lukechampine.com/upnp/igd"
+	"lukechampine.com/upnp/internal/clock"
+	"lukechampine.com/upnp/internal/safexml"
+	"lukechampine.com/upnp/soap"
 )
 
 type GetSpecificPortMappingEntryRequest struct {
@@ -15,7 +26,7 @@ type GetSpecificPortMappingEntryResponse struct {
 	NewInternalClient         string
 	NewEnabled                bool
 	NewPortMappingDescription string
-	NewLeaseDuration          string
+	NewLeaseDuration          uint32
 }
 
 type AddPortMappingRequest struct {
@@ -29,72 +40,544 @@ type AddPortMappingRequest struct {
 	NewLeaseDuration          uint32
 }
 
+type AddAnyPortMappingResponse struct {
+	NewReservedPort uint16
+}
+
 type DeletePortMappingRequest struct {
 	NewRemoteHost   string
 	NewExternalPort uint16
 	NewProtocol     string
 }
 
+type GetGenericPortMappingEntryRequest struct {
+	NewPortMappingIndex uint32
+}
+
+type GetGenericPortMappingEntryResponse struct {
+	NewRemoteHost             string
+	NewExternalPort           uint16
+	NewProtocol               string
+	NewInternalPort           uint16
+	NewInternalClient         string
+	NewEnabled                bool
+	NewPortMappingDescription string
+	NewLeaseDuration          uint32
+}
+
+type GetListOfPortMappingsRequest struct {
+	NewStartPort     uint16
+	NewEndPort       uint16
+	NewProtocol      string
+	NewManage        bool
+	NewNumberOfPorts uint16
+}
+
+type GetListOfPortMappingsResponse struct {
+	NewPortListing string
+}
+
+type PortMappingEntry struct {
+	NewRemoteHost     string `xml:"NewRemoteHost"`
+	NewExternalPort   uint16 `xml:"NewExternalPort"`
+	NewProtocol       string `xml:"NewProtocol"`
+	NewInternalPort   uint16 `xml:"NewInternalPort"`
+	NewInternalClient string `xml:"NewInternalClient"`
+	NewEnabled        bool   `xml:"NewEnabled"`
+	NewDescription    string `xml:"NewDescription"`
+	NewLeaseTime      uint32 `xml:"NewLeaseTime"`
+}
+
+type PortMappingList struct {
+	Entries []PortMappingEntry `xml:"PortMappingEntry"`
+}
+
 type GetExternalIPAddressResponse struct {
 	NewExternalIPAddress string
 }
 
+type GetStatusInfoResponse struct {
+	NewConnectionStatus    string
+	NewLastConnectionError string
+	NewUptime              uint32
+}
+
+type GetConnectionTypeInfoResponse struct {
+	NewConnectionType          string
+	NewPossibleConnectionTypes string
+}
+
+type SetConnectionTypeRequest struct {
+	NewConnectionType string
+}
+
+type GetNATRSIPStatusResponse struct {
+	NewRSIPAvailable bool
+	NewNATEnabled    bool
+}
+
 type IGDClient struct {
-	urlBase string
-	srv     Service
+	httpClient   *http.Client
+	location     string
+	root         igd.Device // without embedded devices or services
+	server       string
+	deviceUDN    string // UDN of the (possibly embedded) device offering srv
+	controlURL   string
+	eventSubURL  string
+	scpdURL      string
+	srv          igd.Service
+	quirks       *quirkSet
+	serialize    bool
+	strictLeases bool
+	untrusted    bool // skip checkURL
+	retries      int
+	backoff      time.Duration
+	log          Logger
+	metrics      Metrics
+	clock        clock.Clock
+}
+
+var controlLocks struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}
+
+func lockControlURL(url string) func() {
+	controlLocks.Lock()
+	if controlLocks.m == nil {
+		controlLocks.m = make(map[string]*sync.Mutex)
+	}
+	mu, ok := controlLocks.m[url]
+	if !ok {
+		mu = new(sync.Mutex)
+		controlLocks.m[url] = mu
+	}
+	controlLocks.Unlock()
+	mu.Lock()
+	return mu.Unlock
 }
 
-func (igd IGDClient) performAction(actionName string, req interface{}, resp interface{}) error {
-	return performSOAPAction(igd.urlBase+igd.srv.ControlURL, igd.srv.ServiceType, actionName, req, resp)
+func (c IGDClient) Serialized() IGDClient {
+	c.serialize = true
+	return c
 }
 
-func (igd IGDClient) GetSpecificPortMappingEntry(req GetSpecificPortMappingEntryRequest) (resp GetSpecificPortMappingEntryResponse, err error) {
-	err = igd.performAction("GetSpecificPortMappingEntry", req, &resp)
+// IsSerialized reports whether the client sends at most one request at a time
+// to its control URL.
+func (c IGDClient) IsSerialized() bool {
+	return c.serialize
+}
+
+// WithRetries returns a client that retries transient failures up to n times,
+// waiting backoff before the first retry and doubling the wait thereafter.
+func (c IGDClient) WithRetries(n int, backoff time.Duration) IGDClient {
+	c.retries = n
+	c.backoff = backoff
+	return c
+}
+
+// WithClock returns a client that waits between retries according to clk.
+func (c IGDClient) WithClock(clk clock.Clock) IGDClient {
+	c.clock = clk
+	return c
+}
+
+// WithHTTPClient returns a client that performs requests via hc.
+func (c IGDClient) WithHTTPClient(hc *http.Client) IGDClient {
+	c.httpClient = hc
+	return c
+}
+
+// HTTPClient returns the client used to perform requests.
+func (c IGDClient) HTTPClient() *http.Client {
+	return c.httpClient
+}
+
+// Clock returns the client's clock, which defaults to the system clock.
+func (c IGDClient) Clock() clock.Clock {
+	return clock.Or(c.clock)
+}
+
+func (c IGDClient) performAction(ctx context.Context, actionName string, req interface{}, resp interface{}) (err error) {
+	if c.metrics != nil {
+		c.metrics.ActionStarted(actionName)
+		defer func(start time.Time) {
+			c.metrics.ActionCompleted(actionName, time.Since(start), err)
+		}(time.Now())
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.performActionOnce(ctx, actionName, req, resp)
+		if attempt >= c.retries || !soap.IsTransient(err) {
+			return err
+		}
+		c.debug("retrying UPnP action", "action", actionName, "backoff", backoff)
+		if !clock.Sleep(c.Clock(), backoff, ctx.Done()) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (c IGDClient) performActionOnce(ctx context.Context, actionName string, req interface{}, resp interface{}) error {
+	if c.serialize {
+		defer lockControlURL(c.controlURL)()
+	}
+	start := time.Now()
+	err := soap.CallWithOptions(ctx, c.httpClient, c.controlURL, c.srv.ServiceType, actionName, req, resp, c.quirks.get().soapOptions())
+	c.logAction(actionName, time.Since(start), err)
+	return err
+}
+
+// Call invokes an arbitrary action on the service.
+func (c IGDClient) Call(ctx context.Context, actionName string, req interface{}, resp interface{}) error {
+	return c.performAction(ctx, actionName, req, resp)
+}
+
+func (c IGDClient) GetSpecificPortMappingEntry(ctx context.Context, req GetSpecificPortMappingEntryRequest) (resp GetSpecificPortMappingEntryResponse, err error) {
+	err = c.performAction(ctx, "GetSpecificPortMappingEntry", req, &resp)
 	return
 }
 
-func (igd IGDClient) AddPortMapping(req AddPortMappingRequest) error {
-	return igd.performAction("AddPortMapping", req, nil)
+// WithStrictLeases returns a client that never substitutes a permanent lease
+// for the requested one.
+func (c IGDClient) WithStrictLeases() IGDClient {
+	c.strictLeases = true
+	return c
+}
+
+// addMapping performs an AddPortMapping or AddAnyPortMapping action. Unless
+// strict leases are enabled, the lease is omitted on devices that only support
+// permanent leases; if the device reports as much, the quirk is recorded and
+// the action is retried.
+func (c IGDClient) addMapping(ctx context.Context, actionName string, req AddPortMappingRequest, resp interface{}) error {
+	if c.strictLeases {
+		return c.performAction(ctx, actionName, req, resp)
+	}
+	if c.Quirks().PermanentLeasesOnly {
+		req.NewLeaseDuration = 0
+	}
+	err := c.performAction(ctx, actionName, req, resp)
+	if req.NewLeaseDuration != 0 && errors.Is(err, errOnlyPermanentLeasesSupported) {
+		c.debug("router only supports permanent leases; retrying without lease", "url", c.controlURL)
+		c.AddQuirks(Quirks{PermanentLeasesOnly: true})
+		req.NewLeaseDuration = 0
+		err = c.performAction(ctx, actionName, req, resp)
+	}
+	return err
 }
 
-func (igd IGDClient) DeletePortMapping(req DeletePortMappingRequest) error {
-	return igd.performAction("DeletePortMapping", req, nil)
+var errOnlyPermanentLeasesSupported = &soap.UPnPError{Code: 725}
+
+func (c IGDClient) AddPortMapping(ctx context.Context, req AddPortMappingRequest) error {
+	return c.addMapping(ctx, "AddPortMapping", req, nil)
 }
 
-func (igd IGDClient) GetExternalIPAddress() (resp GetExternalIPAddressResponse, err error) {
-	err = igd.performAction("GetExternalIPAddress", nil, &resp)
+func (c IGDClient) AddAnyPortMapping(ctx context.Context, req AddPortMappingRequest) (resp AddAnyPortMappingResponse, err error) {
+	err = c.addMapping(ctx, "AddAnyPortMapping", req, &resp)
 	return
 }
 
-func (igd IGDClient) Location() string {
-	return igd.urlBase
+func (c IGDClient) DeletePortMapping(ctx context.Context, req DeletePortMappingRequest) error {
+	return c.performAction(ctx, "DeletePortMapping", req, nil)
 }
 
-func (igd IGDClient) ServiceType() string {
-	return igd.srv.ServiceType
+func (c IGDClient) GetGenericPortMappingEntry(ctx context.Context, req GetGenericPortMappingEntryRequest) (resp GetGenericPortMappingEntryResponse, err error) {
+	err = c.performAction(ctx, "GetGenericPortMappingEntry", req, &resp)
+	return
 }
 
-func IGDClientsByURL(ctx context.Context, url string) ([]IGDClient, error) {
-	rd, err := DeviceByURL(ctx, url)
+// controlNamespace is the namespace of the QueryStateVariable action.
+const controlNamespace = "urn:schemas-upnp-org:control-1-0"
+
+// QueryStateVariable returns the value of the named state variable via the
+// QueryStateVariable action. The action was deprecated by UPnP 1.0, but some
+// routers still support it.
+func (c IGDClient) QueryStateVariable(ctx context.Context, name string) (string, error) {
+	req := struct {
+		VarName string `xml:"u:varName"`
+	}{name}
+	var resp struct {
+		Return string `xml:"return"`
+	}
+	c.srv.ServiceType = controlNamespace
+	err := c.performAction(ctx, "QueryStateVariable", req, &resp)
+	return resp.Return, err
+}
+
+func (c IGDClient) GetListOfPortMappings(ctx context.Context, req GetListOfPortMappingsRequest) (list PortMappingList, err error) {
+	var resp GetListOfPortMappingsResponse
+	if err = c.performAction(ctx, "GetListOfPortMappings", req, &resp); err != nil {
+		return
+	}
+	if err = safexml.Unmarshal([]byte(resp.NewPortListing), &list); err != nil {
+		err = fmt.Errorf("invalid port listing: %w", err)
+	}
+	return
+}
+
+func (c IGDClient) GetExternalIPAddress(ctx context.Context) (resp GetExternalIPAddressResponse, err error) {
+	err = c.performAction(ctx, "GetExternalIPAddress", nil, &resp)
+	return
+}
+
+func (c IGDClient) GetStatusInfo(ctx context.Context) (resp GetStatusInfoResponse, err error) {
+	err = c.performAction(ctx, "GetStatusInfo", nil, &resp)
+	return
+}
+
+func (c IGDClient) RequestConnection(ctx context.Context) error {
+	return c.performAction(ctx, "RequestConnection", nil, nil)
+}
+
+func (c IGDClient) ForceTermination(ctx context.Context) error {
+	return c.performAction(ctx, "ForceTermination", nil, nil)
+}
+
+func (c IGDClient) GetConnectionTypeInfo(ctx context.Context) (resp GetConnectionTypeInfoResponse, err error) {
+	err = c.performAction(ctx, "GetConnectionTypeInfo", nil, &resp)
+	return
+}
+
+func (c IGDClient) SetConnectionType(ctx context.Context, req SetConnectionTypeRequest) error {
+	return c.performAction(ctx, "SetConnectionType", req, nil)
+}
+
+func (c IGDClient) GetNATRSIPStatus(ctx context.Context) (resp GetNATRSIPStatusResponse, err error) {
+	err = c.performAction(ctx, "GetNATRSIPStatus", nil, &resp)
+	return
+}
+
+// SCPD fetches the service's description.
+func (c IGDClient) SCPD(ctx context.Context) (igd.SCPD, error) {
+	return igd.SCPDByURL(ctx, c.httpClient, c.scpdURL)
+}
+
+// Root returns the metadata of the root device. Its PresentationURL, if any,
+// is absolute.
+func (c IGDClient) Root() igd.Device {
+	return c.root
+}
+
+// Server returns the device's SERVER header, which identifies its OS and UPnP
+// stack, e.g. "Linux/5.4 UPnP/1.1 MiniUPnPd/2.2.1".
+func (c IGDClient) Server() string {
+	return c.server
+}
+
+// WithServer returns a client that reports server as the device's SERVER
+// header, e.g. the one sent in its SSDP response, which often differs from
+// the one sent with its description. Quirks associated with server are
+// recorded.
+func (c IGDClient) WithServer(server string) IGDClient {
+	if server == "" {
+		return c
+	}
+	c.server = server
+	c.quirks.add(detectQuirks(server, c.root.ModelName))
+	return c
+}
+
+// Identity returns a string that uniquely identifies the service across
+// description URLs, or the empty string if the device does not specify a UDN.
+func (c IGDClient) Identity() string {
+	if c.root.UDN == "" {
+		return ""
+	}
+	return c.root.UDN + " " + c.srv.ServiceType + " " + c.srv.ControlURL
+}
+
+// Location returns the URL of the device description.
+func (c IGDClient) Location() string {
+	return c.location
+}
+
+func (c IGDClient) ServiceType() string {
+	return c.srv.ServiceType
+}
+
+// ClientsByURL returns clients for the services of the specified types offered
+// by the device at url. Unless untrusted is set, an error is returned if any of
+// the device's URLs point at a host other than url's that is not on the local
+// network.
+func ClientsByURL(ctx context.Context, client *http.Client, url string, untrusted bool, serviceTypes ...string) ([]IGDClient, error) {
+	if client == nil {
+		client = DirectHTTPClient
+	}
+	rd, err := igd.DeviceByURL(ctx, client, url)
 	if err != nil {
 		return nil, err
 	}
-
+	if base := strings.TrimSpace(rd.URLBase); base != "" && !untrusted {
+		if err := checkURL(url, base); err != nil {
+			return nil, err
+		}
+	}
 	var clients []IGDClient
-	var visit func(Device)
-	visit = func(d Device) {
-		for _, srv := range d.Services {
-			switch srv.ServiceType {
-			case "urn:schemas-upnp-org:service:WANPPPConnection:1",
-				"urn:schemas-upnp-org:service:WANIPConnection:1",
-				"urn:schemas-upnp-org:service:WANIPConnection:2":
-				clients = append(clients, IGDClient{rd.URLBase, srv})
-			}
+	quirks := &quirkSet{q: detectQuirks(rd.Server, rd.Device.ModelName)}
+	root := rd.Device
+	root.Devices, root.Services = nil, nil
+	root.UDN = strings.TrimSpace(root.UDN)
+	if root.PresentationURL != "" {
+		// the presentation URL is optional and purely informational, so a
+		// malformed one shouldn't make the device unusable
+		if u, err := rd.ResolveURL(root.PresentationURL); err == nil {
+			root.PresentationURL = u
+		} else {
+			root.PresentationURL = ""
+		}
+	}
+	for _, ds := range findServices(rd.Device, serviceTypes) {
+		srv := ds.srv
+		srv.ServiceType = strings.TrimSpace(srv.ServiceType)
+		controlURL, err := rd.ResolveURL(srv.ControlURL)
+		if err != nil {
+			return nil, err
+		}
+		eventSubURL, err := rd.ResolveURL(srv.EventSubURL)
+		if err != nil {
+			return nil, err
 		}
-		for _, d := range d.Devices {
-			visit(d)
+		scpdURL, err := rd.ResolveURL(srv.SCPDURL)
+		if err != nil {
+			return nil, err
 		}
+		if !untrusted {
+			for _, u := range []string{controlURL, eventSubURL, scpdURL} {
+				if err := checkURL(url, u); err != nil {
+					return nil, err
+				}
+			}
+		}
+		clients = append(clients, IGDClient{
+			httpClient:  client,
+			location:    url,
+			root:        root,
+			server:      rd.Server,
+			deviceUDN:   ds.udn,
+			controlURL:  controlURL,
+			eventSubURL: eventSubURL,
+			scpdURL:     scpdURL,
+			quirks:      quirks,
+			srv:         srv,
+			untrusted:   untrusted,
+		})
 	}
-	visit(rd.Device)
 	return clients, nil
 }
+
+type deviceService struct {
+	udn string
+	srv igd.Service
+}
+
+// findServices is like (igd.Device).FindServices, but also returns the UDN of
+// the device offering each service. If no service types are specified, every
+// service is returned.
+func findServices(d igd.Device, serviceTypes []string) []deviceService {
+	var dss []deviceService
+	for _, srv := range d.Services {
+		if len(serviceTypes) == 0 {
+			dss = append(dss, deviceService{strings.TrimSpace(d.UDN), srv})
+		}
+		for _, st := range serviceTypes {
+			if igd.Implements(srv.ServiceType, st) {
+				dss = append(dss, deviceService{strings.TrimSpace(d.UDN), srv})
+				break
+			}
+		}
+	}
+	for _, d := range d.Devices {
+		dss = append(dss, findServices(d, serviceTypes)...)
+	}
+	return dss
+}
+
+// DeviceUDN returns the UDN of the (possibly embedded) device offering the
+// service.
+func (c IGDClient) DeviceUDN() string {
+	return c.deviceUDN
+}
+
+// Siblings returns clients for other services on the same device, configured
+// identically to c. If no service types are specified, clients for every
+// service are returned.
+func (c IGDClient) Siblings(ctx context.Context, serviceTypes ...string) ([]IGDClient, error) {
+	clients, err := ClientsByURL(ctx, c.httpClient, c.Location(), c.untrusted, serviceTypes...)
+	for i := range clients {
+		clients[i].quirks = c.quirks
+		clients[i].serialize = c.serialize
+		clients[i].strictLeases = c.strictLeases
+		clients[i].retries = c.retries
+		clients[i].backoff = c.backoff
+		clients[i].log = c.log
+		clients[i].metrics = c.metrics
+		clients[i].clock = c.clock
+		if c.server != "" {
+			clients[i].server = c.server
+		}
+	}
+	return clients, err
+}
+
+func IGDClientsByURL(ctx context.Context, client *http.Client, url string, untrusted bool) ([]IGDClient, error) {
+	return ClientsByURL(ctx, client, url, untrusted,
+		igd.WANPPPConnection1,
+		igd.WANIPConnection1,
+	)
+}
+
+// ClientState is the information required to reconstruct an IGDClient without
+// fetching the device description.
+type ClientState struct {
+	Location    string
+	ControlURL  string
+	EventSubURL string
+	SCPDURL     string
+	Service     igd.Service
+	Root        igd.Device
+	Server      string
+	Quirks      Quirks
+}
+
+// State returns the client's state.
+func (c IGDClient) State() ClientState {
+	return ClientState{
+		Location:    c.location,
+		ControlURL:  c.controlURL,
+		EventSubURL: c.eventSubURL,
+		SCPDURL:     c.scpdURL,
+		Service:     c.srv,
+		Root:        c.root,
+		Server:      c.server,
+		Quirks:      c.Quirks(),
+	}
+}
+
+// ClientFromState reconstructs a client from its state. Unless untrusted is
+// set, an error is returned if any of its URLs point at a host other than the
+// location's that is not on the local network.
+func ClientFromState(client *http.Client, s ClientState, untrusted bool) (IGDClient, error) {
+	if client == nil {
+		client = DirectHTTPClient
+	}
+	if !untrusted {
+		for _, u := range []string{s.ControlURL, s.EventSubURL, s.SCPDURL} {
+			if err := checkURL(s.Location, u); err != nil {
+				return IGDClient{}, err
+			}
+		}
+	}
+	return IGDClient{
+		httpClient:  client,
+		location:    s.Location,
+		controlURL:  s.ControlURL,
+		eventSubURL: s.EventSubURL,
+		scpdURL:     s.SCPDURL,
+		srv:         s.Service,
+		root:        s.Root,
+		server:      s.Server,
+		quirks:      &quirkSet{q: s.Quirks},
+		untrusted:   untrusted,
+	}, nil
+}
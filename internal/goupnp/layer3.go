@@ -0,0 +1,54 @@
+package goupnp
+
+import (
+	"context"
+	"strings"
+
+	"lukechampine.com/upnp/igd"
+)
+
+type GetDefaultConnectionServiceResponse struct {
+	NewDefaultConnectionService string
+}
+
+func (c IGDClient) GetDefaultConnectionService(ctx context.Context) (resp GetDefaultConnectionServiceResponse, err error) {
+	err = c.performAction(ctx, "GetDefaultConnectionService", nil, &resp)
+	return
+}
+
+// isConnectionService reports whether c is the service identified by conn,
+// a value returned by GetDefaultConnectionService of the form
+// "<device UDN>,<serviceId>".
+func (c IGDClient) isConnectionService(conn string) bool {
+	i := strings.IndexByte(conn, ',')
+	if i < 0 {
+		return false
+	}
+	udn, id := strings.TrimSpace(conn[:i]), strings.TrimSpace(conn[i+1:])
+	return udn == c.deviceUDN && id == strings.TrimSpace(c.srv.ServiceID)
+}
+
+// DefaultConnection returns the index of the client that the router's
+// Layer3Forwarding service reports as its default WAN connection. All clients
+// must belong to the same device. If the router does not offer
+// Layer3Forwarding, or reports a service not among clients, DefaultConnection
+// returns false.
+func DefaultConnection(ctx context.Context, clients []IGDClient) (int, bool) {
+	if len(clients) == 0 {
+		return 0, false
+	}
+	l3fs, err := clients[0].Siblings(ctx, igd.Layer3Forwarding1)
+	if err != nil || len(l3fs) == 0 {
+		return 0, false
+	}
+	resp, err := l3fs[0].GetDefaultConnectionService(ctx)
+	if err != nil {
+		return 0, false
+	}
+	for i, c := range clients {
+		if c.isConnectionService(resp.NewDefaultConnectionService) {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,53 @@
+package goupnp
+
+import (
+	"errors"
+	"time"
+
+	"lukechampine.com/upnp/internal/logging"
+	"lukechampine.com/upnp/soap"
+)
+
+// A Logger receives debug messages, accompanied by alternating keys and
+// values in the style of log/slog.
+type Logger = logging.Logger
+
+// Metrics receives notifications of each action performed by a client.
+type Metrics interface {
+	ActionStarted(action string)
+	ActionCompleted(action string, elapsed time.Duration, err error)
+}
+
+// WithMetrics returns a client that reports each action it performs to m.
+func (c IGDClient) WithMetrics(m Metrics) IGDClient {
+	c.metrics = m
+	return c
+}
+
+// WithLogger returns a client that logs each action it performs.
+func (c IGDClient) WithLogger(l Logger) IGDClient {
+	c.log = l
+	return c
+}
+
+func (c IGDClient) debug(msg string, args ...interface{}) {
+	if c.log != nil {
+		c.log.Debug(msg, args...)
+	}
+}
+
+func (c IGDClient) logAction(actionName string, elapsed time.Duration, err error) {
+	if c.log == nil {
+		return
+	}
+	args := []interface{}{"action", actionName, "service", c.srv.ServiceType, "url", c.controlURL, "elapsed", elapsed}
+	if err == nil {
+		c.log.Debug("UPnP action succeeded", args...)
+		return
+	}
+	var upnpErr *soap.UPnPError
+	if errors.As(err, &upnpErr) {
+		args = append(args, "code", upnpErr.Code)
+	}
+	c.log.Debug("UPnP action failed", append(args, "err", err)...)
+}
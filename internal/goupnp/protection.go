@@ -0,0 +1,57 @@
+package goupnp
+
+import (
+	"context"
+)
+
+type GetSupportedProtocolsResponse struct {
+	ProtocolList string
+}
+
+type SendSetupMessageRequest struct {
+	ProtocolType string
+	InMessage    string
+}
+
+type SendSetupMessageResponse struct {
+	OutMessage string
+}
+
+type GetUserLoginChallengeRequest struct {
+	ProtocolType string
+	Name         string
+}
+
+type GetUserLoginChallengeResponse struct {
+	Salt      string
+	Challenge string
+}
+
+type UserLoginRequest struct {
+	ProtocolType  string
+	Challenge     string
+	Authenticator string
+}
+
+func (c IGDClient) GetSupportedProtocols(ctx context.Context) (resp GetSupportedProtocolsResponse, err error) {
+	err = c.performAction(ctx, "GetSupportedProtocols", nil, &resp)
+	return
+}
+
+func (c IGDClient) SendSetupMessage(ctx context.Context, req SendSetupMessageRequest) (resp SendSetupMessageResponse, err error) {
+	err = c.performAction(ctx, "SendSetupMessage", req, &resp)
+	return
+}
+
+func (c IGDClient) GetUserLoginChallenge(ctx context.Context, req GetUserLoginChallengeRequest) (resp GetUserLoginChallengeResponse, err error) {
+	err = c.performAction(ctx, "GetUserLoginChallenge", req, &resp)
+	return
+}
+
+func (c IGDClient) UserLogin(ctx context.Context, req UserLoginRequest) error {
+	return c.performAction(ctx, "UserLogin", req, nil)
+}
+
+func (c IGDClient) UserLogout(ctx context.Context) error {
+	return c.performAction(ctx, "UserLogout", nil, nil)
+}
@@ -0,0 +1,99 @@
+package goupnp
+
+import (
+	"strings"
+	"sync"
+
+	"lukechampine.com/upnp/soap"
+)
+
+// Quirks describes the ways in which a device deviates from the UPnP spec.
+//
+// Note that NewRemoteHost is always sent as an explicit empty element, which
+// every known device accepts, so no quirk is needed for it.
+type Quirks struct {
+	// NumericBooleans indicates that the device only accepts "1" and "0" as
+	// boolean arguments.
+	NumericBooleans bool
+	// UppercaseSOAPAction indicates that the device only recognizes the
+	// SOAPACTION header if its name is entirely uppercase.
+	UppercaseSOAPAction bool
+	// PermanentLeasesOnly indicates that the device rejects mappings with a
+	// non-zero lease duration. Leases are omitted when forwarding ports on such
+	// devices.
+	PermanentLeasesOnly bool
+}
+
+func (q Quirks) union(o Quirks) Quirks {
+	return Quirks{
+		NumericBooleans:     q.NumericBooleans || o.NumericBooleans,
+		UppercaseSOAPAction: q.UppercaseSOAPAction || o.UppercaseSOAPAction,
+		PermanentLeasesOnly: q.PermanentLeasesOnly || o.PermanentLeasesOnly,
+	}
+}
+
+func (q Quirks) soapOptions() soap.Options {
+	return soap.Options{
+		NumericBooleans:     q.NumericBooleans,
+		UppercaseSOAPAction: q.UppercaseSOAPAction,
+	}
+}
+
+// knownQuirks lists firmwares with known quirks. An entry matches if its
+// non-empty fields are substrings of the corresponding values reported by the
+// device, so entries should be as specific as possible; each must cite a
+// capture or issue demonstrating the quirk on that firmware. Quirks of other
+// devices can be supplied by callers via upnp.WithQuirks.
+var knownQuirks = []struct {
+	server string
+	model  string
+	quirks Quirks
+}{}
+
+// detectQuirks returns the known quirks of a device with the specified SERVER
+// header and model name.
+func detectQuirks(server, model string) Quirks {
+	var q Quirks
+	for _, k := range knownQuirks {
+		if (k.server == "" || strings.Contains(server, k.server)) &&
+			(k.model == "" || strings.Contains(model, k.model)) {
+			q = q.union(k.quirks)
+		}
+	}
+	return q
+}
+
+// quirkSet is shared by every client for the same device, so that quirks
+// learned via one client apply to all of them.
+type quirkSet struct {
+	mu sync.Mutex
+	q  Quirks
+}
+
+func (qs *quirkSet) get() Quirks {
+	if qs == nil {
+		return Quirks{}
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.q
+}
+
+func (qs *quirkSet) add(q Quirks) {
+	if qs == nil {
+		return
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.q = qs.q.union(q)
+}
+
+// Quirks returns the known quirks of the client's device.
+func (c IGDClient) Quirks() Quirks {
+	return c.quirks.get()
+}
+
+// AddQuirks records additional quirks of the client's device.
+func (c IGDClient) AddQuirks(q Quirks) {
+	c.quirks.add(q)
+}
@@ -0,0 +1,44 @@
+package goupnp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ErrUntrustedDevice is returned for devices whose URLs point outside the
+// local network.
+var ErrUntrustedDevice = errors.New("device is not on the local network")
+
+// IsLocalHost reports whether host is a loopback, private, or link-local IP
+// address, ignoring any zone.
+func IsLocalHost(host string) bool {
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast())
+}
+
+// checkURL returns an error unless u is empty, or points at the same host as
+// location or at a local address. Otherwise, a device could direct requests
+// (including SOAP bodies) to arbitrary servers.
+func checkURL(location, u string) error {
+	if u == "" {
+		return nil
+	}
+	lu, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if pu.Hostname() != lu.Hostname() && !IsLocalHost(pu.Hostname()) {
+		return fmt.Errorf("%w: %q points at %v", ErrUntrustedDevice, u, pu.Host)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package goupnp
+
+import (
+	"context"
+)
+
+type GetCommonLinkPropertiesResponse struct {
+	NewWANAccessType              string
+	NewLayer1UpstreamMaxBitRate   uint32
+	NewLayer1DownstreamMaxBitRate uint32
+	NewPhysicalLinkStatus         string
+}
+
+type GetTotalBytesSentResponse struct {
+	NewTotalBytesSent uint64
+}
+
+type GetTotalBytesReceivedResponse struct {
+	NewTotalBytesReceived uint64
+}
+
+type GetTotalPacketsSentResponse struct {
+	NewTotalPacketsSent uint64
+}
+
+type GetTotalPacketsReceivedResponse struct {
+	NewTotalPacketsReceived uint64
+}
+
+func (c IGDClient) GetCommonLinkProperties(ctx context.Context) (resp GetCommonLinkPropertiesResponse, err error) {
+	err = c.performAction(ctx, "GetCommonLinkProperties", nil, &resp)
+	return
+}
+
+func (c IGDClient) GetTotalBytesSent(ctx context.Context) (resp GetTotalBytesSentResponse, err error) {
+	err = c.performAction(ctx, "GetTotalBytesSent", nil, &resp)
+	return
+}
+
+func (c IGDClient) GetTotalBytesReceived(ctx context.Context) (resp GetTotalBytesReceivedResponse, err error) {
+	err = c.performAction(ctx, "GetTotalBytesReceived", nil, &resp)
+	return
+}
+
+func (c IGDClient) GetTotalPacketsSent(ctx context.Context) (resp GetTotalPacketsSentResponse, err error) {
+	err = c.performAction(ctx, "GetTotalPacketsSent", nil, &resp)
+	return
+}
+
+func (c IGDClient) GetTotalPacketsReceived(ctx context.Context) (resp GetTotalPacketsReceivedResponse, err error) {
+	err = c.performAction(ctx, "GetTotalPacketsReceived", nil, &resp)
+	return
+}
@@ -0,0 +1,16 @@
+// Package logging defines the logger interface shared by the packages of this
+// module, so that a single value can be passed to all of them.
+package logging
+
+// A Logger receives debug messages, accompanied by alternating keys and
+// values in the style of log/slog. A *slog.Logger satisfies this interface.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+}
+
+// Nop is a Logger that discards all messages.
+var Nop Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
@@ -0,0 +1,86 @@
+// Package safexml guards against hostile XML documents, such as device
+// descriptions and SOAP responses served by a malicious device on the LAN.
+package safexml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// MaxSize is the maximum size of a document, in bytes. Real descriptions
+	// and SOAP responses are a few kilobytes at most.
+	MaxSize = 1 << 20
+	// MaxDepth is the maximum nesting depth of a document's elements.
+	MaxDepth = 64
+)
+
+// ErrTooLarge is returned when a document exceeds MaxSize.
+var ErrTooLarge = fmt.Errorf("XML document exceeds %v bytes", MaxSize)
+
+// Read reads r in full, returning ErrTooLarge if it exceeds MaxSize.
+func Read(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(b) > MaxSize {
+		return nil, ErrTooLarge
+	}
+	return b, nil
+}
+
+// Check returns an error if doc exceeds MaxSize, nests elements more than
+// MaxDepth deep, or declares entities. (encoding/xml does not expand
+// user-defined entities, but there is no legitimate reason for a device to
+// declare them.)
+func Check(doc []byte) error {
+	if len(doc) > MaxSize {
+		return ErrTooLarge
+	}
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var depth int
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if depth++; depth > MaxDepth {
+				return fmt.Errorf("XML elements nested more than %v deep", MaxDepth)
+			}
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			if bytes.Contains(tok, []byte("ENTITY")) {
+				return errors.New("XML entity declarations are not allowed")
+			}
+		}
+	}
+}
+
+// NewDecoder reads r in full and checks it, returning a decoder for its
+// contents.
+func NewDecoder(r io.Reader) (*xml.Decoder, error) {
+	doc, err := Read(r)
+	if err != nil {
+		return nil, err
+	} else if err := Check(doc); err != nil {
+		return nil, err
+	}
+	return xml.NewDecoder(bytes.NewReader(doc)), nil
+}
+
+// Unmarshal is like xml.Unmarshal, but checks doc first.
+func Unmarshal(doc []byte, v interface{}) error {
+	if err := Check(doc); err != nil {
+		return err
+	}
+	return xml.Unmarshal(doc, v)
+}
@@ -0,0 +1,145 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"lukechampine.com/upnp/stun"
+)
+
+// An AddressClass classifies a router's external IP address.
+type AddressClass int
+
+// Address classes.
+const (
+	// AddressPublic indicates a publicly-routable address. Forwarded ports
+	// should be reachable from the internet.
+	AddressPublic AddressClass = iota
+	// AddressPrivate indicates an RFC 1918 address, meaning the router is
+	// itself behind another NAT ("double NAT"). Forwarded ports will not be
+	// reachable from the internet unless the upstream NAT forwards them too.
+	AddressPrivate
+	// AddressCGNAT indicates an address in the RFC 6598 shared address space
+	// (100.64.0.0/10), meaning the ISP uses carrier-grade NAT. Forwarded ports
+	// will not be reachable from the internet.
+	AddressCGNAT
+	// AddressUnspecified indicates that the router reported no address (or
+	// 0.0.0.0), typically because its WAN connection is down.
+	AddressUnspecified
+	// AddressInvalid indicates that the router reported an address that could
+	// not be parsed, or one that is not routable at all, such as a loopback
+	// or link-local address.
+	AddressInvalid
+)
+
+// String implements fmt.Stringer.
+func (c AddressClass) String() string {
+	switch c {
+	case AddressPublic:
+		return "public"
+	case AddressPrivate:
+		return "private"
+	case AddressCGNAT:
+		return "cgnat"
+	case AddressUnspecified:
+		return "unspecified"
+	case AddressInvalid:
+		return "invalid"
+	default:
+		return "unknown"
+	}
+}
+
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// ClassifyAddress returns the class of the specified external IP address.
+func ClassifyAddress(ip string) AddressClass {
+	if ip == "" {
+		return AddressUnspecified
+	}
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return AddressInvalid
+	case addr.IsUnspecified():
+		return AddressUnspecified
+	case addr.IsPrivate():
+		return AddressPrivate
+	case cgnatNet.Contains(addr):
+		return AddressCGNAT
+	case addr.IsLoopback(), addr.IsLinkLocalUnicast(), addr.IsMulticast():
+		return AddressInvalid
+	default:
+		return AddressPublic
+	}
+}
+
+// ExternalIPInfo describes a router's external IP address.
+type ExternalIPInfo struct {
+	IP    string
+	Class AddressClass
+}
+
+// Reachable reports whether ports forwarded on the router are likely to be
+// reachable from the internet. Note that a public address does not guarantee
+// reachability, since an upstream firewall may still block inbound traffic.
+func (i ExternalIPInfo) Reachable() bool {
+	return i.Class == AddressPublic
+}
+
+// ExternalIPInfo returns the router's external IP along with its
+// classification. Applications can use this to warn users that forwarding
+// ports will not make them reachable, e.g. because they are behind a double
+// NAT or carrier-grade NAT.
+func (d Device) ExternalIPInfo(ctx context.Context) (ExternalIPInfo, error) {
+	ip, err := d.ExternalIP(ctx)
+	if err != nil {
+		return ExternalIPInfo{}, err
+	}
+	return ExternalIPInfo{IP: ip, Class: ClassifyAddress(ip)}, nil
+}
+
+// An ExternalIPCheck compares the external IP reported by a router with the
+// address of this host as observed by a STUN server on the internet.
+type ExternalIPCheck struct {
+	// Reported is the external IP reported by the router.
+	Reported netip.Addr
+	// Observed is the address observed by the STUN server.
+	Observed netip.Addr
+}
+
+// Match reports whether the reported and observed addresses are the same. A
+// mismatch indicates that the router is behind another NAT (whether or not
+// its external IP is private), or that it is misreporting its WAN address;
+// either way, forwarded ports are unlikely to be reachable at the reported
+// address.
+func (c ExternalIPCheck) Match() bool {
+	return c.Reported == c.Observed
+}
+
+// CheckExternalIP compares the router's external IP with the address of this
+// host as observed by the STUN server at stunServer (host:port), or
+// stun.DefaultServer if stunServer is empty. Note that this sends a packet to
+// a server on the internet.
+func (d Device) CheckExternalIP(ctx context.Context, stunServer string) (ExternalIPCheck, error) {
+	var observed netip.AddrPort
+	var stunErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		observed, stunErr = stun.ExternalAddr(ctx, stunServer)
+	}()
+	reported, err := d.ExternalAddr(ctx)
+	<-done
+	if err != nil {
+		return ExternalIPCheck{}, err
+	} else if stunErr != nil {
+		return ExternalIPCheck{}, fmt.Errorf("couldn't determine observed address: %w", stunErr)
+	}
+	return ExternalIPCheck{
+		Reported: reported.Unmap(),
+		Observed: observed.Addr().Unmap(),
+	}, nil
+}
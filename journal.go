@@ -0,0 +1,227 @@
+package upnp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A MappingStore persists the mappings recorded by a Journal.
+type MappingStore interface {
+	// Load returns the mappings most recently passed to Save, or nil if Save
+	// has never been called.
+	Load() ([]PortMapping, error)
+	// Save replaces the stored mappings.
+	Save([]PortMapping) error
+}
+
+// FileStore is a MappingStore that stores mappings as JSON in the file at the
+// specified path. The file is replaced atomically on each Save.
+type FileStore string
+
+// Load implements MappingStore.
+func (fs FileStore) Load() ([]PortMapping, error) {
+	b, err := ioutil.ReadFile(string(fs))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ms []PortMapping
+	if err := json.Unmarshal(b, &ms); err != nil {
+		return nil, fmt.Errorf("invalid mapping journal: %w", err)
+	}
+	return ms, nil
+}
+
+// Save implements MappingStore.
+func (fs FileStore) Save(ms []PortMapping) error {
+	b, err := json.MarshalIndent(ms, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(string(fs)), filepath.Base(string(fs))+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(fs))
+}
+
+// A Journal forwards ports on a Device and records each mapping it creates in
+// a MappingStore, so that if the process crashes, the next run can find the
+// mappings it left behind: NewJournal loads them, after which they can be
+// cleared via ClearAll or re-created via Adopt. A store should only be used by
+// one Journal at a time.
+type Journal struct {
+	d     Device
+	store MappingStore
+
+	mu       sync.Mutex
+	mappings map[mappingKey]PortMapping
+}
+
+var _ Forwarder = (*Journal)(nil)
+
+// NewJournal returns a Journal for the Device, initially containing the
+// mappings recorded in store by previous runs.
+func NewJournal(d Device, store MappingStore) (*Journal, error) {
+	ms, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		d:        d,
+		store:    store,
+		mappings: make(map[mappingKey]PortMapping, len(ms)),
+	}
+	for _, m := range ms {
+		j.mappings[keyFor(m.ExternalPort, m.Protocol)] = m
+	}
+	return j, nil
+}
+
+// saveLocked persists the journal's mappings. j.mu must be held.
+func (j *Journal) saveLocked() error {
+	ms := make([]PortMapping, 0, len(j.mappings))
+	for _, m := range j.mappings {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, k int) bool {
+		if ms[i].ExternalPort != ms[k].ExternalPort {
+			return ms[i].ExternalPort < ms[k].ExternalPort
+		}
+		return ms[i].Protocol < ms[k].Protocol
+	})
+	if err := j.store.Save(ms); err != nil {
+		return fmt.Errorf("couldn't save mapping journal: %w", err)
+	}
+	return nil
+}
+
+func (j *Journal) record(m PortMapping) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.mappings[keyFor(m.ExternalPort, m.Protocol)] = m
+	return j.saveLocked()
+}
+
+func (j *Journal) forget(port uint16, proto string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.mappings, keyFor(port, proto))
+	return j.saveLocked()
+}
+
+// Mappings returns the mappings currently recorded in the journal. The Lease
+// of each is the lease that was requested, not the time remaining.
+func (j *Journal) Mappings() []PortMapping {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ms := make([]PortMapping, 0, len(j.mappings))
+	for _, m := range j.mappings {
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// Forward is like Device.Forward, but records the mapping in the journal. The
+// mapping is recorded before it is created, so that it is not lost if the
+// process crashes in between; if the mapping cannot be created, the journal
+// entry is restored to its previous state.
+func (j *Journal) Forward(ctx context.Context, port uint16, proto string, desc string, opts ...ForwardOption) error {
+	req, err := j.d.mappingRequest(port, proto, desc, opts)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	prev, existed := j.mappings[keyFor(port, proto)]
+	j.mu.Unlock()
+	if err := j.record(requestedMapping(req)); err != nil {
+		return err
+	}
+	if err := j.d.Forward(ctx, port, proto, desc, opts...); err != nil {
+		if existed {
+			j.record(prev)
+		} else {
+			j.forget(port, proto)
+		}
+		return err
+	}
+	return nil
+}
+
+// ForwardAny is like Device.ForwardAny, but records the mapping in the
+// journal.
+func (j *Journal) ForwardAny(ctx context.Context, port uint16, proto string, desc string, opts ...ForwardOption) (uint16, error) {
+	req, err := j.d.mappingRequest(port, proto, desc, opts)
+	if err != nil {
+		return 0, err
+	}
+	ext, err := j.d.ForwardAny(ctx, port, proto, desc, opts...)
+	if err != nil {
+		return 0, err
+	}
+	req.NewExternalPort = ext
+	return ext, j.record(requestedMapping(req))
+}
+
+// Clear clears the specified mapping and removes it from the journal.
+func (j *Journal) Clear(ctx context.Context, port uint16, proto string) error {
+	if err := j.d.Clear(ctx, port, proto); err != nil {
+		return err
+	}
+	return j.forget(port, proto)
+}
+
+// IsForwarded implements Forwarder.
+func (j *Journal) IsForwarded(ctx context.Context, port uint16, proto string) bool {
+	return j.d.IsForwarded(ctx, port, proto)
+}
+
+// ExternalIP implements Forwarder.
+func (j *Journal) ExternalIP(ctx context.Context) (string, error) {
+	return j.d.ExternalIP(ctx)
+}
+
+// ClearAll clears every mapping recorded in the journal, e.g. those left
+// behind by a previous run, and removes them from the journal.
+func (j *Journal) ClearAll(ctx context.Context) error {
+	var firstErr error
+	for _, m := range j.Mappings() {
+		if err := j.Clear(ctx, m.ExternalPort, m.Protocol); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Adopt re-creates every mapping recorded in the journal, e.g. those left
+// behind by a previous run, so that the current run can continue to use them.
+// Mappings that still exist are simply refreshed.
+func (j *Journal) Adopt(ctx context.Context) error {
+	var firstErr error
+	for _, m := range j.Mappings() {
+		err := j.d.Forward(ctx, m.ExternalPort, m.Protocol, m.Description,
+			WithInternalPort(m.InternalPort), WithInternalClient(m.InternalClient), WithLease(m.Lease))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,152 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A Lease keeps a port mapping alive by periodically renewing it before it
+// expires.
+type Lease struct {
+	d     Device
+	port  uint16
+	proto string
+	desc  string
+	dur   time.Duration
+	opts  []ForwardOption
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+// Err returns the error encountered by the most recent renewal attempt, or nil
+// if it succeeded.
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Close stops renewing the lease and clears the mapping.
+func (l *Lease) Close() error {
+	return l.CloseContext(context.Background())
+}
+
+// CloseContext is like Close, but uses the provided context when clearing the
+// mapping.
+func (l *Lease) CloseContext(ctx context.Context) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return errors.New("lease already closed")
+	}
+	l.closed = true
+	l.mu.Unlock()
+	l.cancel()
+	<-l.done
+	return l.d.Clear(ctx, l.port, l.proto)
+}
+
+func (l *Lease) renew(ctx context.Context) error {
+	err := l.d.Forward(ctx, l.port, l.proto, l.desc, append(l.opts, WithLease(l.dur))...)
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+	return err
+}
+
+// jitter returns d, randomly adjusted by up to 10% in either direction.
+func jitter(d time.Duration) time.Duration {
+	return d - d/10 + time.Duration(rand.Int63n(int64(d/5)+1))
+}
+
+func (l *Lease) run() {
+	defer close(l.done)
+	// renew at the halfway point, so that we have plenty of time to retry if
+	// the router is temporarily unavailable
+	interval := l.dur / 2
+	const minBackoff = time.Second
+	backoff := minBackoff
+	timer := l.d.client.Clock().NewTimer(jitter(interval))
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-timer.C():
+		}
+		// don't let a wedged router stall renewals indefinitely
+		ctx, cancel := context.WithTimeout(l.ctx, interval)
+		err := l.renew(ctx)
+		cancel()
+		if err != nil {
+			timer.Reset(jitter(backoff))
+			if backoff *= 2; backoff > interval {
+				backoff = interval
+			}
+		} else {
+			timer.Reset(jitter(interval))
+			backoff = minBackoff
+		}
+	}
+}
+
+// KeepAlive forwards the specified port with a lease of the specified
+// duration, and then renews the lease in the background until Close is called.
+// Renewals are scheduled at roughly half of the lease duration; failed
+// renewals are retried with exponential backoff. Any supplied options are
+// applied to each renewal.
+//
+// The provided context is only used for the initial forwarding request.
+func (d Device) KeepAlive(ctx context.Context, port uint16, proto string, desc string, lease time.Duration, opts ...ForwardOption) (*Lease, error) {
+	if lease < 2*time.Second {
+		return nil, errors.New("lease duration must be at least 2 seconds")
+	}
+	l := &Lease{
+		d:     d,
+		port:  port,
+		proto: proto,
+		desc:  desc,
+		dur:   lease,
+		opts:  opts[:len(opts):len(opts)],
+		done:  make(chan struct{}),
+	}
+	if err := l.renew(ctx); err != nil {
+		return nil, err
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	go l.run()
+	return l, nil
+}
+
+// LeaseRemaining returns the time remaining before the mapping for the
+// specified port expires, or 0 if the mapping is permanent. If the port is not
+// mapped, the returned error wraps ErrNoSuchEntryInArray.
+func (d Device) LeaseRemaining(ctx context.Context, port uint16, proto string) (time.Duration, error) {
+	m, err := d.specificMapping(ctx, port, proto)
+	return m.Lease, err
+}
+
+// Renew re-adds the existing mapping for the specified port with a fresh lease
+// of the specified duration, preserving its internal port, client, and
+// description. It is intended for applications that implement their own
+// renewal logic; see KeepAlive for automatic renewal.
+func (d Device) Renew(ctx context.Context, port uint16, proto string, lease time.Duration) error {
+	m, err := d.specificMapping(ctx, port, proto)
+	if err != nil {
+		return err
+	}
+	return d.Forward(ctx, port, proto, m.Description,
+		WithInternalPort(m.InternalPort),
+		WithInternalClient(m.InternalClient),
+		WithLease(lease),
+	)
+}
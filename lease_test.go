@@ -0,0 +1,58 @@
+package upnp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"lukechampine.com/upnp"
+	"lukechampine.com/upnp/upnptest"
+)
+
+func TestKeepAliveRenews(t *testing.T) {
+	clk := upnptest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv := upnptest.NewServer()
+	defer srv.Close()
+	srv.SetClock(clk)
+
+	ctx := context.Background()
+	d, err := upnp.Connect(ctx, srv.Location(), upnp.WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const lease = time.Minute
+	l, err := d.KeepAlive(ctx, 8000, upnp.TCP, "keepalive", lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if n := srv.Calls("AddPortMapping"); n != 1 {
+		t.Fatalf("expected 1 AddPortMapping call, got %v", n)
+	}
+
+	// renewals happen at roughly half the lease; advancing by 40s each time
+	// triggers exactly one, and after three rounds the original lease would
+	// have long since expired
+	for i := 2; i <= 4; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(40 * time.Second)
+		clk.BlockUntil(1) // the renewal timer is re-armed once the renewal completes
+		if n := srv.Calls("AddPortMapping"); n != i {
+			t.Fatalf("expected %v AddPortMapping calls, got %v", i, n)
+		} else if err := l.Err(); err != nil {
+			t.Fatal("renewal failed:", err)
+		}
+		ms := srv.Mappings()
+		if len(ms) != 1 {
+			t.Fatalf("expected 1 mapping, got %v", len(ms))
+		} else if ms[0].Lease != lease {
+			t.Fatalf("expected renewed lease of %v, got %v", lease, ms[0].Lease)
+		}
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	} else if ms := srv.Mappings(); len(ms) != 0 {
+		t.Fatalf("expected mapping to be cleared, got %v", ms)
+	}
+}
@@ -0,0 +1,77 @@
+package upnp
+
+import (
+	"context"
+
+	"lukechampine.com/upnp/igd"
+)
+
+// LinkProperties describes the physical properties of a router's WAN link.
+type LinkProperties struct {
+	// AccessType is typically one of "DSL", "POTS", "Cable", or "Ethernet".
+	AccessType string
+	// UpstreamMaxBitRate and DownstreamMaxBitRate are the maximum bit rates
+	// of the link, in bits per second.
+	UpstreamMaxBitRate   uint32
+	DownstreamMaxBitRate uint32
+	// PhysicalLinkStatus is typically one of "Up", "Down", "Initializing", or
+	// "Unavailable".
+	PhysicalLinkStatus string
+}
+
+// TrafficCounters reports the total traffic that has passed through a router's
+// WAN interface. Note that many routers use 32-bit counters, which wrap around
+// frequently.
+type TrafficCounters struct {
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+}
+
+// LinkProperties returns the properties of the router's WAN link, via the
+// WANCommonInterfaceConfig service.
+func (d Device) LinkProperties(ctx context.Context) (LinkProperties, error) {
+	c, err := d.serviceClient(ctx, igd.WANCommonInterfaceConfig1)
+	if err != nil {
+		return LinkProperties{}, err
+	}
+	resp, err := c.GetCommonLinkProperties(ctx)
+	return LinkProperties{
+		AccessType:           resp.NewWANAccessType,
+		UpstreamMaxBitRate:   resp.NewLayer1UpstreamMaxBitRate,
+		DownstreamMaxBitRate: resp.NewLayer1DownstreamMaxBitRate,
+		PhysicalLinkStatus:   resp.NewPhysicalLinkStatus,
+	}, err
+}
+
+// TrafficCounters returns the router's WAN traffic counters, via the
+// WANCommonInterfaceConfig service.
+func (d Device) TrafficCounters(ctx context.Context) (TrafficCounters, error) {
+	c, err := d.serviceClient(ctx, igd.WANCommonInterfaceConfig1)
+	if err != nil {
+		return TrafficCounters{}, err
+	}
+	bs, err := c.GetTotalBytesSent(ctx)
+	if err != nil {
+		return TrafficCounters{}, err
+	}
+	br, err := c.GetTotalBytesReceived(ctx)
+	if err != nil {
+		return TrafficCounters{}, err
+	}
+	ps, err := c.GetTotalPacketsSent(ctx)
+	if err != nil {
+		return TrafficCounters{}, err
+	}
+	pr, err := c.GetTotalPacketsReceived(ctx)
+	if err != nil {
+		return TrafficCounters{}, err
+	}
+	return TrafficCounters{
+		BytesSent:       bs.NewTotalBytesSent,
+		BytesReceived:   br.NewTotalBytesReceived,
+		PacketsSent:     ps.NewTotalPacketsSent,
+		PacketsReceived: pr.NewTotalPacketsReceived,
+	}, nil
+}
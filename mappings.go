@@ -0,0 +1,322 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"lukechampine.com/upnp/igd"
+	"lukechampine.com/upnp/internal/goupnp"
+)
+
+// A PortMapping is an entry in a router's port mapping table.
+type PortMapping struct {
+	ExternalPort   uint16
+	InternalPort   uint16
+	InternalClient string
+	Protocol       string
+	Description    string
+	Enabled        bool
+	// Lease is the time remaining before the mapping expires. A Lease of 0
+	// indicates a permanent mapping.
+	Lease time.Duration
+}
+
+// specificMapping returns the router's entry for the specified port.
+func (d Device) specificMapping(ctx context.Context, port uint16, proto string) (PortMapping, error) {
+	proto, err := ParseProto(proto)
+	if err != nil {
+		return PortMapping{}, err
+	}
+	resp, err := d.client.GetSpecificPortMappingEntry(ctx, goupnp.GetSpecificPortMappingEntryRequest{
+		NewExternalPort: port,
+		NewProtocol:     proto,
+	})
+	if err != nil {
+		return PortMapping{}, err
+	}
+	return PortMapping{
+		ExternalPort:   port,
+		InternalPort:   resp.NewInternalPort,
+		InternalClient: resp.NewInternalClient,
+		Protocol:       proto,
+		Description:    resp.NewPortMappingDescription,
+		Enabled:        resp.NewEnabled,
+		Lease:          time.Duration(resp.NewLeaseDuration) * time.Second,
+	}, nil
+}
+
+// A MappingStatus describes the state of a single port mapping.
+type MappingStatus struct {
+	PortMapping
+	// Exists is false if the port is not mapped at all.
+	Exists bool
+	// Local is true if the port is mapped to this host.
+	Local bool
+}
+
+// MappingStatus returns the status of the mapping for the specified port.
+// Unlike IsForwarded, MappingStatus distinguishes between ports that are not
+// mapped, ports mapped to other hosts, and failures to communicate with the
+// router.
+func (d Device) MappingStatus(ctx context.Context, port uint16, proto string) (MappingStatus, error) {
+	m, err := d.specificMapping(ctx, port, proto)
+	if errors.Is(err, ErrNoSuchEntryInArray) {
+		return MappingStatus{}, nil
+	} else if err != nil {
+		return MappingStatus{}, err
+	}
+	return MappingStatus{
+		PortMapping: m,
+		Exists:      true,
+		Local:       m.InternalClient == d.internalIP,
+	}, nil
+}
+
+// A MappingFilter restricts the mappings visited by WalkMappings. The zero
+// value matches every mapping.
+type MappingFilter struct {
+	// Protocol is "TCP" or "UDP"; if empty, both are matched.
+	Protocol string
+	// StartPort and EndPort bound the external port, inclusive. An EndPort of
+	// 0 is treated as 65535.
+	StartPort uint16
+	EndPort   uint16
+	// DescriptionPrefix, if set, matches only mappings whose description
+	// begins with it.
+	DescriptionPrefix string
+}
+
+func (f MappingFilter) bounds() (start, end uint16) {
+	start, end = f.StartPort, f.EndPort
+	if start == 0 {
+		start = 1
+	}
+	if end == 0 {
+		end = 65535
+	}
+	return
+}
+
+func (f MappingFilter) protocols() []string {
+	if f.Protocol != "" {
+		return []string{strings.ToUpper(f.Protocol)}
+	}
+	return []string{TCP, UDP}
+}
+
+// Match reports whether m satisfies the filter.
+func (f MappingFilter) Match(m PortMapping) bool {
+	start, end := f.bounds()
+	return (f.Protocol == "" || strings.EqualFold(m.Protocol, f.Protocol)) &&
+		m.ExternalPort >= start && m.ExternalPort <= end &&
+		strings.HasPrefix(m.Description, f.DescriptionPrefix)
+}
+
+// mappingPageSize is the number of entries requested per
+// GetListOfPortMappings call.
+const mappingPageSize = 256
+
+// Mappings returns all of the entries in the router's port mapping table. For
+// routers with very large tables, WalkMappings avoids holding the entire table
+// in memory.
+func (d Device) Mappings(ctx context.Context) ([]PortMapping, error) {
+	var ms []PortMapping
+	err := d.WalkMappings(ctx, MappingFilter{}, func(m PortMapping) bool {
+		ms = append(ms, m)
+		return true
+	})
+	return ms, err
+}
+
+// WalkMappings calls fn for each entry in the router's port mapping table that
+// matches f, stopping early if fn returns false. Entries are fetched a page at
+// a time, so the table is never loaded into memory all at once.
+//
+// On IGDv2 routers, the protocol and port range are filtered by the router
+// itself; otherwise, and for descriptions, the filtering is done locally.
+// Mappings must not be added or removed from within fn, since doing so may
+// cause entries to be skipped or visited twice.
+func (d Device) WalkMappings(ctx context.Context, f MappingFilter, fn func(PortMapping) bool) error {
+	if f.Protocol != "" {
+		if _, err := ianaProto(f.Protocol); err != nil {
+			return err
+		}
+	}
+	if start, end := f.bounds(); start > end {
+		return fmt.Errorf("invalid port range %v-%v", start, end)
+	}
+	if igd.ServiceVersion(d.client.ServiceType()) >= 2 {
+		visited, err := d.walkList(ctx, f, fn)
+		if err == nil || visited {
+			return err
+		}
+		// not all IGDv2 routers implement GetListOfPortMappings properly;
+		// fall back to the v1 method
+	}
+	return d.walkGeneric(ctx, f, fn)
+}
+
+// EntryAt returns the entry at index i of the router's port mapping table,
+// via GetGenericPortMappingEntry. This is the primitive underlying
+// WalkMappings on older routers, exposed for tools that implement their own
+// scanning strategies. Indices are only meaningful while the table is
+// unchanged; adding or removing a mapping may shift every entry after it.
+//
+// If i is past the end of the table, the router returns a fault, typically
+// ErrSpecifiedArrayIndexInvalid, though some routers use other codes.
+func (d Device) EntryAt(ctx context.Context, i int) (PortMapping, error) {
+	if i < 0 || uint64(i) > math.MaxUint32 {
+		return PortMapping{}, fmt.Errorf("invalid index %v", i)
+	}
+	resp, err := d.client.GetGenericPortMappingEntry(ctx, goupnp.GetGenericPortMappingEntryRequest{
+		NewPortMappingIndex: uint32(i),
+	})
+	if err != nil {
+		return PortMapping{}, err
+	}
+	return PortMapping{
+		ExternalPort:   resp.NewExternalPort,
+		InternalPort:   resp.NewInternalPort,
+		InternalClient: resp.NewInternalClient,
+		Protocol:       resp.NewProtocol,
+		Description:    resp.NewPortMappingDescription,
+		Enabled:        resp.NewEnabled,
+		Lease:          time.Duration(resp.NewLeaseDuration) * time.Second,
+	}, nil
+}
+
+// EntryCount returns the number of entries in the router's port mapping
+// table, as reported by its PortMappingNumberOfEntries state variable. The
+// variable can only be queried via the deprecated QueryStateVariable action;
+// routers that don't support it return a fault, typically ErrInvalidAction.
+// (Such routers may still report the count via events; see Subscribe.)
+func (d Device) EntryCount(ctx context.Context) (int, error) {
+	v, err := d.client.QueryStateVariable(ctx, "PortMappingNumberOfEntries")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("router reported invalid PortMappingNumberOfEntries %q", v)
+	}
+	return int(n), nil
+}
+
+// walkGeneric walks the mapping table by index, via GetGenericPortMappingEntry.
+func (d Device) walkGeneric(ctx context.Context, f MappingFilter, fn func(PortMapping) bool) error {
+	// guard against routers that ignore the index and return the same entry
+	// forever
+	const maxEntries = 2 * 65536
+	for i := 0; i < maxEntries; i++ {
+		m, err := d.EntryAt(ctx, i)
+		if err != nil {
+			// routers signal the end of the table with a fault, typically
+			// SpecifiedArrayIndexInvalid, though some use other codes; but a
+			// different fault on the first entry more likely means the action
+			// failed outright (e.g. ActionNotAuthorized)
+			if errors.Is(err, ErrSpecifiedArrayIndexInvalid) || errors.Is(err, ErrNoSuchEntryInArray) || (i > 0 && isFault(err)) {
+				return nil
+			}
+			return err
+		}
+		if f.Match(m) && !fn(m) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// walkList walks the mapping table in pages of port ranges, via
+// GetListOfPortMappings. It reports whether fn was called, since once it has
+// been, falling back to walkGeneric would visit entries twice.
+func (d Device) walkList(ctx context.Context, f MappingFilter, fn func(PortMapping) bool) (visited bool, err error) {
+	start, end := f.bounds()
+	for _, proto := range f.protocols() {
+		for next := start; ; {
+			list, err := d.client.GetListOfPortMappings(ctx, goupnp.GetListOfPortMappingsRequest{
+				NewStartPort:     next,
+				NewEndPort:       end,
+				NewProtocol:      proto,
+				NewManage:        true,
+				NewNumberOfPorts: mappingPageSize,
+			})
+			if errors.Is(err, ErrNoSuchEntryInArray) {
+				// the range is empty
+				break
+			} else if err != nil {
+				return visited, err
+			}
+			last, progressed := next, false
+			for _, e := range list.Entries {
+				m := PortMapping{
+					ExternalPort:   e.NewExternalPort,
+					InternalPort:   e.NewInternalPort,
+					InternalClient: e.NewInternalClient,
+					Protocol:       e.NewProtocol,
+					Description:    e.NewDescription,
+					Enabled:        e.NewEnabled,
+					Lease:          time.Duration(e.NewLeaseTime) * time.Second,
+				}
+				if m.ExternalPort >= next {
+					progressed = true
+					if m.ExternalPort > last {
+						last = m.ExternalPort
+					}
+				}
+				if !f.Match(m) {
+					continue
+				}
+				visited = true
+				if !fn(m) {
+					return visited, nil
+				}
+			}
+			// a short page means the range is exhausted; likewise, stop if the
+			// router ignored the start port, lest we loop forever
+			if len(list.Entries) < mappingPageSize || !progressed || last == end {
+				break
+			}
+			next = last + 1
+		}
+	}
+	return visited, nil
+}
+
+// ClearByDescription un-forwards every mapping whose description begins with
+// prefix, returning the number of mappings cleared. This is useful for cleaning
+// up mappings left behind by a previous run of a program that crashed before it
+// could clear them. Mappings are cleared regardless of which host they point
+// at. prefix must not be empty, since that would clear every mapping on the
+// router.
+func (d Device) ClearByDescription(ctx context.Context, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, errors.New("description prefix must not be empty")
+	}
+	// collect the matching mappings first, since clearing them mid-walk
+	// would shift the indices of the remaining entries
+	var ms []PortMapping
+	err := d.WalkMappings(ctx, MappingFilter{DescriptionPrefix: prefix}, func(m PortMapping) bool {
+		ms = append(ms, m)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var firstErr error
+	for _, m := range ms {
+		if err := d.Clear(ctx, m.ExternalPort, m.Protocol); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		n++
+	}
+	return n, firstErr
+}
@@ -0,0 +1,68 @@
+package upnp
+
+// The following methods return metadata from the router's device description.
+// Routers are not required to report all of them, so any may be empty. They
+// are primarily useful for display and diagnostics, e.g. "Forwarding via
+// FRITZ!Box 7590".
+
+// FriendlyName returns the router's user-facing name.
+func (d Device) FriendlyName() string {
+	return d.client.Root().FriendlyName
+}
+
+// Manufacturer returns the name of the router's manufacturer.
+func (d Device) Manufacturer() string {
+	return d.client.Root().Manufacturer
+}
+
+// ModelName returns the router's model name.
+func (d Device) ModelName() string {
+	return d.client.Root().ModelName
+}
+
+// ModelNumber returns the router's model number.
+func (d Device) ModelNumber() string {
+	return d.client.Root().ModelNumber
+}
+
+// SerialNumber returns the router's serial number.
+func (d Device) SerialNumber() string {
+	return d.client.Root().SerialNumber
+}
+
+// PresentationURL returns the URL of the router's web interface.
+func (d Device) PresentationURL() string {
+	return d.client.Root().PresentationURL
+}
+
+// UDN returns the router's Unique Device Name, which identifies it even if it
+// is reachable via several description URLs.
+func (d Device) UDN() string {
+	return d.client.Root().UDN
+}
+
+// ServerString returns the SERVER header that the router reported, which
+// identifies its OS, UPnP stack, and often its firmware, e.g.
+// "Linux/3.14 UPnP/1.1 MiniUPnPd/2.1". The header from the router's SSDP
+// response is preferred; if the Device was obtained via Connect, the header
+// sent with its device description is used instead. It may be empty. Known
+// quirks associated with the header are applied automatically, and it is
+// worth including in bug reports.
+func (d Device) ServerString() string {
+	return d.client.Server()
+}
+
+// BootID returns the BOOTID.UPNP.ORG value that the router reported when it
+// was discovered, or -1 if it did not report one (or if the Device was
+// obtained via Connect). UPnP 1.1 routers increment their BootID each time
+// they reboot, which typically wipes their port mappings; see Watch.
+func (d Device) BootID() int {
+	return d.bootID
+}
+
+// ConfigID returns the CONFIGID.UPNP.ORG value that the router reported when it
+// was discovered, or -1 if unknown. It changes whenever the router's device
+// description changes.
+func (d Device) ConfigID() int {
+	return d.configID
+}
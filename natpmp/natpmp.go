@@ -0,0 +1,239 @@
+// Package natpmp implements a NAT Port Mapping Protocol (RFC 6886) client.
+//
+// NAT-PMP is supported by many routers that do not support UPnP, and is
+// typically used as a fallback when UPnP discovery fails.
+package natpmp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"lukechampine.com/upnp/internal/gateway"
+)
+
+// Port is the UDP port on which NAT-PMP gateways listen.
+const Port = 5351
+
+// DefaultLifetime is the mapping lifetime used by Forward, as recommended by
+// RFC 6886.
+const DefaultLifetime = 2 * time.Hour
+
+const (
+	opExternalAddress = 0
+	opMapUDP          = 1
+	opMapTCP          = 2
+)
+
+// A ResultError is a non-zero result code returned by a NAT-PMP gateway.
+type ResultError uint16
+
+// Error implements error.
+func (e ResultError) Error() string {
+	var msg string
+	switch e {
+	case 1:
+		msg = "unsupported version"
+	case 2:
+		msg = "not authorized/refused"
+	case 3:
+		msg = "network failure"
+	case 4:
+		msg = "out of resources"
+	case 5:
+		msg = "unsupported opcode"
+	default:
+		msg = "unknown error"
+	}
+	return fmt.Sprintf("NAT-PMP error: %s (result code %d)", msg, uint16(e))
+}
+
+// A Mapping is a port mapping created by a NAT-PMP gateway.
+type Mapping struct {
+	InternalPort uint16
+	ExternalPort uint16
+	Lifetime     time.Duration
+}
+
+// A Client communicates with a NAT-PMP gateway.
+type Client struct {
+	gateway net.IP
+}
+
+// Gateway returns the IP address of the gateway.
+func (c *Client) Gateway() string {
+	return c.gateway.String()
+}
+
+// call sends req to the gateway, retransmitting as described in RFC 6886
+// section 3.1, and returns the response, which must be respLen bytes long.
+func (c *Client) call(ctx context.Context, req []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: c.gateway, Port: Port})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	const maxAttempts = 9
+	timeout := 250 * time.Millisecond
+	resp := make([]byte, 16)
+	for i := 0; i < maxAttempts; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(resp)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			} else if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			// ignore responses to other requests
+			if n < 4 || resp[0] != 0 || resp[1] != 128+req[1] {
+				continue
+			} else if code := binary.BigEndian.Uint16(resp[2:]); code != 0 {
+				return nil, ResultError(code)
+			} else if n != respLen {
+				return nil, fmt.Errorf("invalid response length %v", n)
+			}
+			return resp[:n], nil
+		}
+		timeout *= 2
+	}
+	return nil, errors.New("NAT-PMP gateway did not respond")
+}
+
+// ExternalIP returns the gateway's external IP.
+func (c *Client) ExternalIP(ctx context.Context) (string, error) {
+	resp, err := c.call(ctx, []byte{0, opExternalAddress}, 12)
+	if err != nil {
+		return "", err
+	}
+	return net.IP(resp[8:12]).String(), nil
+}
+
+func mapOpcode(proto string) (byte, error) {
+	switch strings.ToUpper(proto) {
+	case "UDP":
+		return opMapUDP, nil
+	case "TCP":
+		return opMapTCP, nil
+	default:
+		return 0, fmt.Errorf("unsupported protocol %q", proto)
+	}
+}
+
+// Map requests a mapping from the specified external port to the specified
+// internal port on this host. The gateway may assign a different external port
+// or lifetime than the ones requested; the actual values are returned.
+func (c *Client) Map(ctx context.Context, proto string, internalPort, externalPort uint16, lifetime time.Duration) (Mapping, error) {
+	op, err := mapOpcode(proto)
+	if err != nil {
+		return Mapping{}, err
+	}
+	req := make([]byte, 12)
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:], internalPort)
+	binary.BigEndian.PutUint16(req[6:], externalPort)
+	binary.BigEndian.PutUint32(req[8:], uint32((lifetime+time.Second-1)/time.Second))
+	resp, err := c.call(ctx, req, 16)
+	if err != nil {
+		return Mapping{}, err
+	}
+	return Mapping{
+		InternalPort: binary.BigEndian.Uint16(resp[8:]),
+		ExternalPort: binary.BigEndian.Uint16(resp[10:]),
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(resp[12:])) * time.Second,
+	}, nil
+}
+
+// Forward forwards the specified port for the specified protocol, which must be
+// "TCP" or "UDP", using DefaultLifetime. NAT-PMP does not support mapping
+// descriptions, so desc is ignored. If the gateway assigns a different external
+// port, the mapping is removed and an error is returned.
+func (c *Client) Forward(ctx context.Context, port uint16, proto string, desc string) error {
+	m, err := c.Map(ctx, proto, port, port, DefaultLifetime)
+	if err != nil {
+		return err
+	} else if m.ExternalPort != port {
+		c.Clear(ctx, port, proto)
+		return fmt.Errorf("gateway assigned external port %v instead of %v", m.ExternalPort, port)
+	}
+	return nil
+}
+
+// Renew refreshes the mapping for the specified port, extending its lifetime to
+// DefaultLifetime. Mappings should be renewed well before they expire.
+func (c *Client) Renew(ctx context.Context, port uint16, proto string) error {
+	return c.Forward(ctx, port, proto, "")
+}
+
+// Clear removes the mapping for the specified internal port.
+func (c *Client) Clear(ctx context.Context, port uint16, proto string) error {
+	_, err := c.Map(ctx, proto, port, 0, 0)
+	return err
+}
+
+// Connect connects to the NAT-PMP gateway at the specified IP address.
+func Connect(ctx context.Context, gateway string) (*Client, error) {
+	ip := net.ParseIP(gateway).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IPv4 gateway address %q", gateway)
+	}
+	c := &Client{gateway: ip}
+	if _, err := c.ExternalIP(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Discover searches for a NAT-PMP gateway on each of the host's IPv4 subnets,
+// returning the first one that responds.
+func Discover(ctx context.Context) (*Client, error) {
+	gws, err := gateway.Candidates()
+	if err != nil {
+		return nil, err
+	} else if len(gws) == 0 {
+		return nil, errors.New("no candidate gateways found")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		c   *Client
+		err error
+	}
+	results := make(chan result, len(gws))
+	for _, gw := range gws {
+		go func(gw net.IP) {
+			c, err := Connect(ctx, gw.String())
+			results <- result{c, err}
+		}(gw)
+	}
+	var errs []string
+	for range gws {
+		r := <-results
+		if r.err == nil {
+			return r.c, nil
+		}
+		errs = append(errs, r.err.Error())
+	}
+	return nil, fmt.Errorf("no NAT-PMP gateway found: %v", strings.Join(errs, "; "))
+}
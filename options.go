@@ -0,0 +1,409 @@
+package upnp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"lukechampine.com/upnp/igd"
+	"lukechampine.com/upnp/internal/goupnp"
+	"lukechampine.com/upnp/internal/logging"
+	"lukechampine.com/upnp/ssdp"
+)
+
+type options struct {
+	httpClient    *http.Client
+	serialize     bool
+	retries       int
+	retryBackoff  time.Duration
+	quirks        Quirks
+	strictLeases  bool
+	searchAddrs   []string
+	ssdp          ssdp.Options
+	interfaces    []string
+	allInterfaces bool
+	log           Logger
+	capture       *Capture
+	metrics       Metrics
+	cache         *DiscoveryCache
+	descTimeout   time.Duration
+	untrusted     bool
+	insecureTLS   bool
+	certPins      *CertificatePins
+	clientCert    *tls.Certificate
+	internalIP    string
+	concurrency   int
+	noGatewayHint bool
+	clock         Clock
+	replay        *Replay
+}
+
+// An Option modifies the behavior of Discover, DiscoverAll, and Connect.
+type Option func(*options)
+
+// WithHTTPClient sets the HTTP client used to fetch device descriptions and to
+// communicate with the resulting Devices. The default client dials devices
+// directly, ignoring any proxy configured in the environment.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) { o.httpClient = c }
+}
+
+// WithProxyFromEnvironment causes requests to devices to honor the HTTP_PROXY,
+// HTTPS_PROXY, and NO_PROXY environment variables. By default, these variables
+// are ignored, since devices are almost always on the LAN.
+func WithProxyFromEnvironment() Option {
+	return func(o *options) { o.httpClient = goupnp.ProxyHTTPClient }
+}
+
+// WithSerializedRequests causes the resulting Devices to send at most one
+// request at a time to each control URL, even when called concurrently. Some
+// router firmwares misbehave when handed concurrent requests.
+func WithSerializedRequests() Option {
+	return func(o *options) { o.serialize = true }
+}
+
+// WithRetries causes the resulting Devices to retry requests that fail
+// transiently -- due to a refused connection, a timeout, or an HTTP 5xx
+// response -- up to n times, waiting backoff before the first retry and
+// doubling the wait after each subsequent failure. Requests that fail with a
+// UPnPError are never retried. Some routers briefly stop serving HTTP during
+// DHCP renewals; a handful of retries with a backoff of a few hundred
+// milliseconds is usually enough to ride these out. By default, requests are
+// not retried.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(o *options) { o.retries, o.retryBackoff = n, backoff }
+}
+
+// WithQuirks applies the specified quirks to the resulting Devices, in addition
+// to any that are detected automatically.
+func WithQuirks(q Quirks) Option {
+	return func(o *options) { o.quirks = q }
+}
+
+// WithStrictLeases causes Forward and related methods to return
+// ErrOnlyPermanentLeasesSupported when a router rejects a leased mapping. By
+// default, the mapping is transparently retried without a lease, and the
+// PermanentLeasesOnly quirk is recorded on the Device so that subsequent
+// mappings omit the lease from the outset.
+func WithStrictLeases() Option {
+	return func(o *options) { o.strictLeases = true }
+}
+
+// WithLogger causes discovery and the resulting Devices to log SSDP packets,
+// description fetches, UPnP actions, and faults to l at debug level. This is
+// invaluable when diagnosing a router that refuses to forward ports.
+func WithLogger(l Logger) Option {
+	return func(o *options) { o.log = l }
+}
+
+// WithCapture records every SSDP packet and HTTP message exchanged during
+// discovery, and by the resulting Devices, in c.
+func WithCapture(c *Capture) Option {
+	return func(o *options) { o.capture = c }
+}
+
+// WithMetrics reports discovery and each action performed by the resulting
+// Devices to m.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) { o.metrics = m }
+}
+
+// WithDiscoveryCache causes Discover and DiscoverAll to consult c before
+// searching, and to record the Devices they find in c.
+func WithDiscoveryCache(c *DiscoveryCache) Option {
+	return func(o *options) { o.cache = c }
+}
+
+// WithUntrustedDevices disables the checks that discovery performs on each
+// SSDP response: by default, responses must come from a directly-connected
+// subnet (or from an address passed to WithSearchAddrs), and must advertise a
+// private or link-local description URL, the control, event, and SCPD URLs in
+// the description must point at the same host or another local address, and
+// Devices refuse to follow redirects to other public hosts. This option may be needed when the
+// gateway is only reachable via a routed network, but it allows any host on
+// the LAN to direct requests to arbitrary servers.
+func WithUntrustedDevices() Option {
+	return func(o *options) { o.untrusted = true }
+}
+
+// WithInternalIP sets the address that the resulting Devices' mappings point
+// at by default, overriding the address detected automatically (the first one
+// on the same network as the router). This is useful on hosts with several
+// addresses on that network, e.g. with virtual IPs or containers using host
+// networking. ip must be an IPv4 address.
+func WithInternalIP(ip string) Option {
+	return func(o *options) { o.internalIP = ip }
+}
+
+// WithInsecureLocalTLS skips verification of the certificates presented by
+// devices with private or link-local addresses when fetching descriptions and
+// performing actions over HTTPS. Some IGDv2 routers advertise https://
+// locations with self-signed certificates, which otherwise cannot be used.
+// WithPinnedCertificates offers a more secure alternative.
+func WithInsecureLocalTLS() Option {
+	return func(o *options) { o.insecureTLS = true }
+}
+
+// WithPinnedCertificates trusts the certificate presented by each device with
+// a private or link-local address the first time it is seen, recording it in
+// pins, and rejects any other certificate from that device thereafter. It
+// takes precedence over WithInsecureLocalTLS.
+//
+// Like WithInsecureLocalTLS, this option has no effect if the HTTP client
+// passed to WithHTTPClient does not use an *http.Transport.
+func WithPinnedCertificates(pins *CertificatePins) Option {
+	return func(o *options) { o.certPins = pins }
+}
+
+// WithClientCertificate presents cert to devices with private or link-local
+// addresses when performing actions over HTTPS. Routers implementing
+// DeviceProtection:1 identify control points by their certificate, so a
+// stable certificate is required for a login (see DeviceProtection.Login) to
+// persist across connections.
+//
+// Like WithInsecureLocalTLS, this option has no effect if the HTTP client
+// passed to WithHTTPClient does not use an *http.Transport.
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(o *options) { o.clientCert = &cert }
+}
+
+// WithSearchTimeout sets how long to wait for devices to respond to the SSDP
+// search, i.e. the total duration of discovery. The default is 2 seconds. Slow
+// devices may need longer.
+func WithSearchTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.ssdp.Timeout = timeout }
+}
+
+// WithSearchTarget sets the SSDP search target. The default is
+// "upnp:rootdevice".
+func WithSearchTarget(st string) Option {
+	return func(o *options) { o.ssdp.SearchTarget, o.ssdp.SearchTargets = st, nil }
+}
+
+// IGDSearchTargets are the search targets of Internet Gateway Devices and
+// their WAN connection services. Searching for all of them (via
+// WithSearchTargets) tends to find gateways faster than searching for
+// "upnp:rootdevice", and finds devices that only respond to service-level
+// searches.
+var IGDSearchTargets = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+	igd.WANIPConnection1,
+	igd.WANIPConnection2,
+	igd.WANPPPConnection1,
+}
+
+// WithSearchTargets searches for each of the specified targets in a single
+// pass, replacing the default target.
+func WithSearchTargets(sts ...string) Option {
+	return func(o *options) { o.ssdp.SearchTarget, o.ssdp.SearchTargets = "", sts }
+}
+
+// WithRetransmits sets how many times the SSDP search is retransmitted, to
+// compensate for packet loss. The default is 2.
+func WithRetransmits(n int) Option {
+	return func(o *options) { o.ssdp.Sends = n + 1 }
+}
+
+// WithDescriptionTimeout sets how long to wait for each responding device's
+// description during discovery. The default is 10 seconds. Regardless of this
+// timeout, fetches are abandoned when the context passed to Discover (or
+// DiscoverAllCtx) is done.
+func WithDescriptionTimeout(d time.Duration) Option {
+	return func(o *options) { o.descTimeout = d }
+}
+
+// WithDiscoveryConcurrency sets the maximum number of device descriptions
+// fetched concurrently during discovery. The default is 16. Networks with
+// hundreds of UPnP devices (smart TVs, speakers, cameras, etc.) may benefit
+// from a lower limit.
+func WithDiscoveryConcurrency(n int) Option {
+	return func(o *options) { o.concurrency = n }
+}
+
+// WithSendInterval sets the delay between SSDP retransmits. The default is 5
+// milliseconds; on lossy networks, spacing retransmits further apart (e.g.
+// 250ms) makes it less likely that a single burst of loss drops all of them.
+func WithSendInterval(d time.Duration) Option {
+	return func(o *options) { o.ssdp.SendInterval = d }
+}
+
+// WithSearchMX sets the MX value of the SSDP search: the maximum number of
+// seconds that devices may wait before responding. It must be between 1 and
+// 5. By default, it is derived from the search timeout.
+func WithSearchMX(mx int) Option {
+	return func(o *options) { o.ssdp.MX = mx }
+}
+
+// WithSearchReadBuffer sets the size of the socket receive buffer used for the
+// SSDP search. Raising it can prevent responses from being dropped on networks
+// with many UPnP devices.
+func WithSearchReadBuffer(n int) Option {
+	return func(o *options) { o.ssdp.ReadBuffer = n }
+}
+
+// WithInterfaces restricts the SSDP search to the named network interfaces. By
+// default, the operating system chooses the interface.
+func WithInterfaces(names ...string) Option {
+	return func(o *options) { o.interfaces = names }
+}
+
+// WithAllInterfaces searches on every multicast-capable network interface
+// concurrently, merging the results. This is useful on multi-homed hosts (e.g.
+// with VPNs or container bridges), where the interface chosen by the operating
+// system may not be connected to the gateway.
+func WithAllInterfaces() Option {
+	return func(o *options) { o.allInterfaces = true }
+}
+
+// WithLocalAddrs binds the SSDP search to the specified local IP addresses,
+// overriding WithInterfaces, WithIPv4, and WithIPv6.
+func WithLocalAddrs(ips ...string) Option {
+	return func(o *options) {
+		o.ssdp.LocalAddrs = o.ssdp.LocalAddrs[:0]
+		for _, ip := range ips {
+			o.ssdp.LocalAddrs = append(o.ssdp.LocalAddrs, net.ParseIP(ip))
+		}
+	}
+}
+
+// WithSearchAddrs sends the SSDP search to the specified unicast addresses
+// instead of multicasting it, overriding all other search options. Addresses
+// without a port use the standard SSDP port, 1900. This is useful on networks
+// that filter multicast traffic (e.g. some enterprise Wi-Fi and VM bridges)
+// but where the gateway's address is known, as well as for testing.
+func WithSearchAddrs(addrs ...string) Option {
+	return func(o *options) { o.searchAddrs = addrs }
+}
+
+// WithGatewayHint enables or disables unicasting the SSDP search to the
+// gateway of the OS's default route, in parallel with the multicast search.
+// The gateway responds to a unicast search immediately, whereas devices delay
+// their responses to a multicast search by up to MX seconds, so this usually
+// lets Discover return within milliseconds. It is enabled by default, and has
+// no effect if WithSearchAddrs or WithPacketConn is used.
+func WithGatewayHint(enabled bool) Option {
+	return func(o *options) { o.noGatewayHint = !enabled }
+}
+
+// WithListenConfig creates the SSDP search sockets via lc, allowing socket
+// options such as SO_REUSEADDR, the multicast TTL, or (on Linux) binding to a
+// VRF device to be set in its Control function.
+func WithListenConfig(lc *net.ListenConfig) Option {
+	return func(o *options) { o.ssdp.ListenConfig = lc }
+}
+
+// WithPacketConn sends the SSDP search via conn instead of opening new sockets,
+// overriding WithListenConfig, WithInterfaces, WithLocalAddrs, WithIPv4, and
+// WithIPv6. The search is sent to the addresses specified by WithSearchAddrs,
+// if any, and otherwise to the IPv4 SSDP multicast group. conn is closed when
+// the search completes, so each discovery requires a fresh conn. This is
+// mainly useful for testing, e.g. with a loopback-only socket.
+func WithPacketConn(conn net.PacketConn) Option {
+	return func(o *options) { o.ssdp.Conn = conn }
+}
+
+// WithIPv4 enables or disables searching via IPv4. IPv4 is enabled by default.
+func WithIPv4(enabled bool) Option {
+	return func(o *options) { o.ssdp.IPv4 = enabled }
+}
+
+// WithIPv6 enables or disables searching via IPv6. IPv6 is disabled by
+// default. When enabled, searches are sent to the link-local (ff02::c) and
+// site-local (ff05::c) SSDP groups on each multicast-capable interface.
+// Mappings on gateways discovered via IPv6 point at this host's IPv4 address
+// on the same interface.
+func WithIPv6(enabled bool) Option {
+	return func(o *options) { o.ssdp.IPv6 = enabled }
+}
+
+func applyOptions(opts []Option) (options, error) {
+	o := options{
+		httpClient:  goupnp.DirectHTTPClient,
+		descTimeout: 10 * time.Second,
+		concurrency: 16,
+		ssdp: ssdp.Options{
+			Timeout:      2 * time.Second,
+			SearchTarget: "upnp:rootdevice",
+			Sends:        3,
+			IPv4:         true,
+			IPv6:         false,
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.log == nil {
+		o.log = logging.Nop
+	}
+	o.ssdp.Logger = o.log
+	if o.replay != nil {
+		o.httpClient = o.replay.Client()
+		if o.internalIP == "" {
+			o.internalIP = o.replay.internalIP
+		}
+	}
+	if o.certPins != nil || o.insecureTLS || o.clientCert != nil {
+		o.httpClient = deviceTLSClient(o.httpClient, o.certPins, o.certPins != nil || o.insecureTLS, o.clientCert)
+	}
+	if !o.untrusted {
+		o.httpClient = restrictRedirects(o.httpClient)
+	}
+	if o.capture != nil {
+		o.httpClient = captureClient(o.httpClient, o.capture)
+		o.ssdp.Trace = o.capture.traceSSDP
+	}
+	for _, addr := range o.searchAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), "1900")
+		}
+		ua, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return options{}, err
+		}
+		o.ssdp.Addrs = append(o.ssdp.Addrs, ua)
+	}
+	if o.internalIP != "" {
+		addr, err := netip.ParseAddr(o.internalIP)
+		if err != nil || !addr.Unmap().Is4() {
+			return options{}, fmt.Errorf("invalid internal IP %q: must be an IPv4 address", o.internalIP)
+		}
+		o.internalIP = addr.Unmap().String()
+	}
+	if o.concurrency < 1 {
+		return options{}, errors.New("discovery concurrency must be positive")
+	}
+	if o.descTimeout <= 0 {
+		return options{}, errors.New("description timeout must be positive")
+	}
+	if o.ssdp.MX < 0 || o.ssdp.MX > 5 {
+		return options{}, errors.New("MX must be between 1 and 5")
+	} else if o.ssdp.Sends < 1 {
+		return options{}, errors.New("retransmits must be non-negative")
+	}
+	for _, ip := range o.ssdp.LocalAddrs {
+		if ip == nil {
+			return options{}, errors.New("invalid local address")
+		}
+	}
+	if o.allInterfaces {
+		ifaces, err := ssdp.MulticastInterfaces()
+		if err != nil {
+			return options{}, err
+		}
+		o.ssdp.Interfaces = ifaces
+	}
+	for _, name := range o.interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return options{}, err
+		}
+		o.ssdp.Interfaces = append(o.ssdp.Interfaces, *iface)
+	}
+	return o, nil
+}
@@ -0,0 +1,132 @@
+// Package otelupnp instruments lukechampine.com/upnp with OpenTelemetry
+// tracing. It is a separate module, so that users of upnp who do not need
+// tracing are not forced to depend on OpenTelemetry.
+package otelupnp
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"lukechampine.com/upnp"
+)
+
+const instrumentationName = "lukechampine.com/upnp/otelupnp"
+
+// A Tracer emits spans for UPnP operations.
+type Tracer struct {
+	t trace.Tracer
+}
+
+// NewTracer returns a Tracer that uses the specified provider. If tp is nil,
+// the global provider is used.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{t: tp.Tracer(instrumentationName)}
+}
+
+// end records err on span, if non-nil, and ends it.
+func end(span trace.Span, err error) {
+	if err != nil {
+		var upnpErr *upnp.UPnPError
+		if errors.As(err, &upnpErr) {
+			span.SetAttributes(attribute.Int("upnp.error_code", upnpErr.Code))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Discover is like upnp.Discover, but records the search in a span and wraps
+// the resulting Device.
+func (t *Tracer) Discover(ctx context.Context, opts ...upnp.Option) (*Device, error) {
+	ctx, span := t.t.Start(ctx, "upnp.Discover")
+	d, err := upnp.Discover(ctx, opts...)
+	if err == nil {
+		span.SetAttributes(deviceAttributes(d)...)
+	}
+	end(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return t.Wrap(d), nil
+}
+
+// Wrap returns a Device that records a span for each operation performed on d.
+func (t *Tracer) Wrap(d upnp.Device) *Device {
+	return &Device{d: d, t: t.t, attrs: deviceAttributes(d)}
+}
+
+func deviceAttributes(d upnp.Device) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("upnp.router.location", d.Location()),
+		attribute.String("upnp.router.manufacturer", d.Manufacturer()),
+		attribute.String("upnp.router.model", strings.TrimSpace(d.ModelName()+" "+d.ModelNumber())),
+		attribute.String("upnp.router.server", d.ServerString()),
+	}
+}
+
+// A Device wraps a upnp.Device, recording a span for each operation.
+type Device struct {
+	d     upnp.Device
+	t     trace.Tracer
+	attrs []attribute.KeyValue
+}
+
+var _ upnp.Forwarder = (*Device)(nil)
+
+// Unwrap returns the underlying upnp.Device.
+func (d *Device) Unwrap() upnp.Device {
+	return d.d
+}
+
+func (d *Device) start(ctx context.Context, name string, port uint16, proto string) (context.Context, trace.Span) {
+	ctx, span := d.t.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(d.attrs...))
+	if port != 0 {
+		span.SetAttributes(
+			attribute.Int("upnp.port", int(port)),
+			attribute.String("upnp.protocol", proto),
+		)
+	}
+	return ctx, span
+}
+
+// Forward implements upnp.Forwarder.
+func (d *Device) Forward(ctx context.Context, port uint16, proto string, desc string, opts ...upnp.ForwardOption) error {
+	ctx, span := d.start(ctx, "upnp.Forward", port, proto)
+	err := d.d.Forward(ctx, port, proto, desc, opts...)
+	end(span, err)
+	return err
+}
+
+// Clear implements upnp.Forwarder.
+func (d *Device) Clear(ctx context.Context, port uint16, proto string) error {
+	ctx, span := d.start(ctx, "upnp.Clear", port, proto)
+	err := d.d.Clear(ctx, port, proto)
+	end(span, err)
+	return err
+}
+
+// IsForwarded implements upnp.Forwarder.
+func (d *Device) IsForwarded(ctx context.Context, port uint16, proto string) bool {
+	ctx, span := d.start(ctx, "upnp.IsForwarded", port, proto)
+	ok := d.d.IsForwarded(ctx, port, proto)
+	span.SetAttributes(attribute.Bool("upnp.forwarded", ok))
+	end(span, nil)
+	return ok
+}
+
+// ExternalIP implements upnp.Forwarder.
+func (d *Device) ExternalIP(ctx context.Context) (string, error) {
+	ctx, span := d.start(ctx, "upnp.ExternalIP", 0, "")
+	ip, err := d.d.ExternalIP(ctx)
+	end(span, err)
+	return ip, err
+}
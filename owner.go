@@ -0,0 +1,114 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// An Owner identifies the process that created a mapping. App names the
+// application, and Instance distinguishes between runs of it, e.g. a random
+// value chosen at startup.
+type Owner struct {
+	App      string
+	Instance string
+}
+
+func (o Owner) tag() string {
+	return "[" + o.App + "#" + o.Instance + "]"
+}
+
+func (o Owner) validate() error {
+	if o.App == "" || strings.ContainsAny(o.App+o.Instance, "[]#") {
+		return fmt.Errorf("invalid owner %q: app must be non-empty, and neither field may contain '[', ']', or '#'", o.tag())
+	}
+	return nil
+}
+
+// WithOwner tags the mapping's description with owner, allowing
+// GarbageCollect to identify it later.
+func WithOwner(owner Owner) ForwardOption {
+	return func(o *forwardOptions) { o.owner = &owner }
+}
+
+// MappingOwner returns the owner that a mapping's description was tagged with
+// via WithOwner, if any.
+func MappingOwner(desc string) (Owner, bool) {
+	if !strings.HasSuffix(desc, "]") {
+		return Owner{}, false
+	}
+	i := strings.LastIndexByte(desc, '[')
+	if i < 0 {
+		return Owner{}, false
+	}
+	tag := desc[i+1 : len(desc)-1]
+	j := strings.IndexByte(tag, '#')
+	if j < 0 {
+		return Owner{}, false
+	}
+	return Owner{App: tag[:j], Instance: tag[j+1:]}, true
+}
+
+// portInUse reports whether a socket is bound to the mapping's internal port
+// on this host. Mappings pointing at other hosts are assumed to be in use.
+func (d Device) portInUse(m PortMapping) bool {
+	if m.InternalClient != d.internalIP {
+		return true
+	}
+	addr := net.JoinHostPort(m.InternalClient, strconv.Itoa(int(m.InternalPort)))
+	switch m.Protocol {
+	case TCP:
+		l, err := net.Listen("tcp4", addr)
+		if err != nil {
+			return true
+		}
+		l.Close()
+	case UDP:
+		c, err := net.ListenPacket("udp4", addr)
+		if err != nil {
+			return true
+		}
+		c.Close()
+	default:
+		return true
+	}
+	return false
+}
+
+// GarbageCollect clears orphaned mappings: those tagged (via WithOwner) with
+// owner.App, but by a different instance, for which inUse returns false. It
+// returns the mappings that were cleared. Mappings created by owner.Instance
+// itself are never cleared.
+//
+// If inUse is nil, a mapping is considered in use if it points at a different
+// host, or if a socket on this host is bound to its internal port. This
+// suffices to clean up after crashed processes that had permanent leases;
+// callers that know more about their deployment (e.g. which instances are
+// still alive) can supply a more precise inUse.
+func (d Device) GarbageCollect(ctx context.Context, owner Owner, inUse func(PortMapping) bool) ([]PortMapping, error) {
+	if inUse == nil {
+		inUse = d.portInUse
+	}
+	ms, err := d.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var cleared []PortMapping
+	var firstErr error
+	for _, m := range ms {
+		mo, ok := MappingOwner(m.Description)
+		if !ok || mo.App != owner.App || mo.Instance == owner.Instance || inUse(m) {
+			continue
+		}
+		if err := d.Clear(ctx, m.ExternalPort, m.Protocol); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		cleared = append(cleared, m)
+	}
+	return cleared, firstErr
+}
@@ -0,0 +1,374 @@
+// Package pcp implements a Port Control Protocol (RFC 6887) client.
+//
+// PCP is the successor to NAT-PMP, and is preferred by many newer routers and
+// carrier-grade NAT deployments.
+package pcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"lukechampine.com/upnp/internal/gateway"
+)
+
+// Port is the UDP port on which PCP servers listen.
+const Port = 5351
+
+// AnnouncePort is the UDP port to which PCP servers multicast unsolicited
+// ANNOUNCE responses.
+const AnnouncePort = 5350
+
+// DefaultLifetime is the mapping lifetime used by Forward.
+const DefaultLifetime = 2 * time.Hour
+
+const (
+	version = 2
+
+	opAnnounce = 0
+	opMap      = 1
+
+	headerLen     = 24
+	mapPayloadLen = 36
+)
+
+// A ResultError is a non-zero result code returned by a PCP server.
+type ResultError uint8
+
+// Error implements error.
+func (e ResultError) Error() string {
+	msgs := [...]string{
+		1:  "UNSUPP_VERSION",
+		2:  "NOT_AUTHORIZED",
+		3:  "MALFORMED_REQUEST",
+		4:  "UNSUPP_OPCODE",
+		5:  "UNSUPP_OPTION",
+		6:  "MALFORMED_OPTION",
+		7:  "NETWORK_FAILURE",
+		8:  "NO_RESOURCES",
+		9:  "UNSUPP_PROTOCOL",
+		10: "USER_EX_QUOTA",
+		11: "CANNOT_PROVIDE_EXTERNAL",
+		12: "ADDRESS_MISMATCH",
+		13: "EXCESSIVE_REMOTE_PEERS",
+	}
+	msg := "unknown error"
+	if int(e) < len(msgs) && msgs[e] != "" {
+		msg = msgs[e]
+	}
+	return fmt.Sprintf("PCP error: %s (result code %d)", msg, uint8(e))
+}
+
+// A Mapping is a port mapping created by a PCP server.
+type Mapping struct {
+	InternalPort uint16
+	ExternalPort uint16
+	ExternalIP   string
+	Lifetime     time.Duration
+}
+
+type mappingKey struct {
+	proto byte
+	port  uint16
+}
+
+// A Client communicates with a PCP server.
+type Client struct {
+	gateway net.IP
+
+	mu     sync.Mutex
+	nonces map[mappingKey][12]byte
+	epoch  uint32
+}
+
+// Gateway returns the IP address of the PCP server.
+func (c *Client) Gateway() string {
+	return c.gateway.String()
+}
+
+// Epoch returns the most recent epoch time reported by the server. If the
+// epoch goes backwards, the server has likely lost its mapping state, and
+// mappings should be re-created.
+func (c *Client) Epoch() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+func protoNumber(proto string) (byte, error) {
+	switch strings.ToUpper(proto) {
+	case "TCP":
+		return 6, nil
+	case "UDP":
+		return 17, nil
+	default:
+		return 0, fmt.Errorf("unsupported protocol %q", proto)
+	}
+}
+
+// call sends a request to the server, retransmitting with exponential backoff,
+// and returns the response. The client IP field of the request header is
+// filled in automatically. If nonce is non-nil, responses with a different
+// nonce are ignored.
+func (c *Client) call(ctx context.Context, req []byte, nonce []byte) ([]byte, error) {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: c.gateway, Port: Port})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	copy(req[8:24], conn.LocalAddr().(*net.UDPAddr).IP.To16())
+
+	const maxAttempts = 6
+	timeout := 250 * time.Millisecond
+	resp := make([]byte, 1100) // maximum PCP message size
+	for i := 0; i < maxAttempts; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(resp)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			} else if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			// ignore responses to other requests
+			if n < headerLen || resp[1] != 0x80|req[1] {
+				continue
+			} else if nonce != nil && (n < headerLen+12 || string(resp[headerLen:headerLen+12]) != string(nonce)) {
+				continue
+			} else if resp[0] != version {
+				return nil, ResultError(1)
+			}
+			c.mu.Lock()
+			c.epoch = binary.BigEndian.Uint32(resp[8:])
+			c.mu.Unlock()
+			if resp[3] != 0 {
+				return nil, ResultError(resp[3])
+			}
+			return resp[:n], nil
+		}
+		timeout *= 2
+	}
+	return nil, errors.New("PCP server did not respond")
+}
+
+// Announce sends an ANNOUNCE request to the server, which can be used to check
+// whether the server supports PCP.
+func (c *Client) Announce(ctx context.Context) error {
+	req := make([]byte, headerLen)
+	req[0] = version
+	req[1] = opAnnounce
+	_, err := c.call(ctx, req, nil)
+	return err
+}
+
+func (c *Client) nonce(key mappingKey) [12]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.nonces[key]
+	if !ok {
+		rand.Read(n[:])
+		c.nonces[key] = n
+	}
+	return n
+}
+
+// Map requests a mapping from the specified external port to the specified
+// internal port on this host. The server may assign a different external port
+// or lifetime than the ones requested; the actual values are returned. A
+// lifetime of 0 deletes the mapping.
+func (c *Client) Map(ctx context.Context, proto string, internalPort, externalPort uint16, lifetime time.Duration) (Mapping, error) {
+	pn, err := protoNumber(proto)
+	if err != nil {
+		return Mapping{}, err
+	}
+	key := mappingKey{pn, internalPort}
+	nonce := c.nonce(key)
+
+	req := make([]byte, headerLen+mapPayloadLen)
+	req[0] = version
+	req[1] = opMap
+	binary.BigEndian.PutUint32(req[4:], uint32((lifetime+time.Second-1)/time.Second))
+	p := req[headerLen:]
+	copy(p[:12], nonce[:])
+	p[12] = pn
+	binary.BigEndian.PutUint16(p[16:], internalPort)
+	binary.BigEndian.PutUint16(p[18:], externalPort)
+	copy(p[20:36], net.IPv4zero.To16())
+
+	resp, err := c.call(ctx, req, nonce[:])
+	if err != nil {
+		return Mapping{}, err
+	} else if len(resp) < headerLen+mapPayloadLen {
+		return Mapping{}, fmt.Errorf("invalid response length %v", len(resp))
+	}
+	if lifetime == 0 {
+		c.mu.Lock()
+		delete(c.nonces, key)
+		c.mu.Unlock()
+	}
+	p = resp[headerLen:]
+	return Mapping{
+		InternalPort: binary.BigEndian.Uint16(p[16:]),
+		ExternalPort: binary.BigEndian.Uint16(p[18:]),
+		ExternalIP:   net.IP(p[20:36]).String(),
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(resp[4:])) * time.Second,
+	}, nil
+}
+
+// Forward forwards the specified port for the specified protocol, which must be
+// "TCP" or "UDP", using DefaultLifetime. PCP does not support mapping
+// descriptions, so desc is ignored. If the server assigns a different external
+// port, the mapping is removed and an error is returned.
+func (c *Client) Forward(ctx context.Context, port uint16, proto string, desc string) error {
+	m, err := c.Map(ctx, proto, port, port, DefaultLifetime)
+	if err != nil {
+		return err
+	} else if m.ExternalPort != port {
+		c.Clear(ctx, port, proto)
+		return fmt.Errorf("server assigned external port %v instead of %v", m.ExternalPort, port)
+	}
+	return nil
+}
+
+// Renew refreshes the mapping for the specified port, extending its lifetime to
+// DefaultLifetime. Mappings should be renewed well before they expire.
+func (c *Client) Renew(ctx context.Context, port uint16, proto string) error {
+	return c.Forward(ctx, port, proto, "")
+}
+
+// Clear removes the mapping for the specified internal port.
+func (c *Client) Clear(ctx context.Context, port uint16, proto string) error {
+	_, err := c.Map(ctx, proto, port, 0, 0)
+	return err
+}
+
+// ExternalIP returns the server's external IP. PCP has no dedicated request
+// for this, so ExternalIP creates a short-lived mapping and immediately deletes
+// it.
+func (c *Client) ExternalIP(ctx context.Context) (string, error) {
+	// use the port of a throwaway socket, so that we don't disturb any real
+	// mappings
+	l, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	port := uint16(l.LocalAddr().(*net.UDPAddr).Port)
+	m, err := c.Map(ctx, "UDP", port, 0, time.Minute)
+	if err != nil {
+		return "", err
+	}
+	c.Map(ctx, "UDP", port, 0, 0)
+	return m.ExternalIP, nil
+}
+
+// Connect connects to the PCP server at the specified IP address.
+func Connect(ctx context.Context, gateway string) (*Client, error) {
+	ip := net.ParseIP(gateway)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid gateway address %q", gateway)
+	}
+	c := &Client{
+		gateway: ip,
+		nonces:  make(map[mappingKey][12]byte),
+	}
+	if err := c.Announce(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Discover searches for a PCP server on each of the host's IPv4 subnets,
+// returning the first one that responds.
+func Discover(ctx context.Context) (*Client, error) {
+	gws, err := gateway.Candidates()
+	if err != nil {
+		return nil, err
+	} else if len(gws) == 0 {
+		return nil, errors.New("no candidate gateways found")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		c   *Client
+		err error
+	}
+	results := make(chan result, len(gws))
+	for _, gw := range gws {
+		go func(gw net.IP) {
+			c, err := Connect(ctx, gw.String())
+			results <- result{c, err}
+		}(gw)
+	}
+	var errs []string
+	for range gws {
+		r := <-results
+		if r.err == nil {
+			return r.c, nil
+		}
+		errs = append(errs, r.err.Error())
+	}
+	return nil, fmt.Errorf("no PCP server found: %v", strings.Join(errs, "; "))
+}
+
+// An Announcement is an unsolicited ANNOUNCE message sent by a PCP server,
+// typically after it restarts. Upon receiving one, clients should re-create
+// their mappings.
+type Announcement struct {
+	Gateway string
+	Epoch   uint32
+}
+
+// ListenAnnouncements listens for unsolicited ANNOUNCE messages multicast by
+// PCP servers. The returned channel is closed when ctx is canceled.
+func ListenAnnouncements(ctx context.Context) (<-chan Announcement, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4allsys, Port: AnnouncePort})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	ch := make(chan Announcement)
+	go func() {
+		defer close(ch)
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			} else if n < headerLen || buf[0] != version || buf[1] != 0x80|opAnnounce || buf[3] != 0 {
+				continue
+			}
+			select {
+			case ch <- Announcement{Gateway: addr.IP.String(), Epoch: binary.BigEndian.Uint32(buf[8:])}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,144 @@
+package upnp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"lukechampine.com/upnp/igd"
+	"lukechampine.com/upnp/internal/goupnp"
+)
+
+// deviceJSON is the persisted form of a Device.
+type deviceJSON struct {
+	Location    string `json:"location"`
+	ServiceType string `json:"serviceType"`
+	ControlURL  string `json:"controlURL"`
+	EventSubURL string `json:"eventSubURL,omitempty"`
+	SCPDURL     string `json:"scpdURL,omitempty"`
+	InternalIP  string `json:"internalIP"`
+	PinnedIP    bool   `json:"pinnedIP,omitempty"`
+	BootID      *int   `json:"bootID,omitempty"`
+	ConfigID    *int   `json:"configID,omitempty"`
+
+	UDN             string `json:"udn,omitempty"`
+	FriendlyName    string `json:"friendlyName,omitempty"`
+	Manufacturer    string `json:"manufacturer,omitempty"`
+	ModelName       string `json:"modelName,omitempty"`
+	ModelNumber     string `json:"modelNumber,omitempty"`
+	SerialNumber    string `json:"serialNumber,omitempty"`
+	PresentationURL string `json:"presentationURL,omitempty"`
+	Server          string `json:"server,omitempty"`
+
+	Quirks Quirks `json:"quirks"`
+}
+
+func knownID(id int) *int {
+	if id < 0 {
+		return nil
+	}
+	return &id
+}
+
+func idOrUnknown(id *int) int {
+	if id == nil {
+		return -1
+	}
+	return *id
+}
+
+// MarshalJSON implements json.Marshaler. The resulting JSON captures
+// everything needed to communicate with the router, allowing applications to
+// persist a Device across restarts instead of rediscovering it.
+func (d Device) MarshalJSON() ([]byte, error) {
+	s := d.client.State()
+	return json.Marshal(deviceJSON{
+		Location:        s.Location,
+		ServiceType:     s.Service.ServiceType,
+		ControlURL:      s.ControlURL,
+		EventSubURL:     s.EventSubURL,
+		SCPDURL:         s.SCPDURL,
+		InternalIP:      d.internalIP,
+		PinnedIP:        d.pinnedIP,
+		BootID:          knownID(d.bootID),
+		ConfigID:        knownID(d.configID),
+		UDN:             s.Root.UDN,
+		FriendlyName:    s.Root.FriendlyName,
+		Manufacturer:    s.Root.Manufacturer,
+		ModelName:       s.Root.ModelName,
+		ModelNumber:     s.Root.ModelNumber,
+		SerialNumber:    s.Root.SerialNumber,
+		PresentationURL: s.Root.PresentationURL,
+		Server:          s.Server,
+		Quirks:          s.Quirks,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The resulting Device uses the
+// default options, so ErrUntrustedDevice is returned if its location or other
+// URLs point outside the local network; since the router may have changed in
+// the meantime, callers should call Validate before relying on it, and fall
+// back to Discover if it fails.
+func (d *Device) UnmarshalJSON(b []byte) error {
+	var dj deviceJSON
+	if err := json.Unmarshal(b, &dj); err != nil {
+		return err
+	} else if dj.Location == "" || dj.ServiceType == "" || dj.ControlURL == "" || dj.InternalIP == "" {
+		return errors.New("incomplete device")
+	}
+	u, err := url.Parse(dj.Location)
+	if err != nil {
+		return err
+	} else if !goupnp.IsLocalHost(u.Hostname()) {
+		return fmt.Errorf("%w: location host %q is not a private address", ErrUntrustedDevice, u.Hostname())
+	}
+	client, err := goupnp.ClientFromState(restrictRedirects(goupnp.DirectHTTPClient), goupnp.ClientState{
+		Location:    dj.Location,
+		ControlURL:  dj.ControlURL,
+		EventSubURL: dj.EventSubURL,
+		SCPDURL:     dj.SCPDURL,
+		Service:     igd.Service{ServiceType: dj.ServiceType},
+		Root: igd.Device{
+			UDN:             dj.UDN,
+			FriendlyName:    dj.FriendlyName,
+			Manufacturer:    dj.Manufacturer,
+			ModelName:       dj.ModelName,
+			ModelNumber:     dj.ModelNumber,
+			SerialNumber:    dj.SerialNumber,
+			PresentationURL: dj.PresentationURL,
+		},
+		Server: dj.Server,
+		Quirks: dj.Quirks,
+	}, false)
+	if err != nil {
+		return err
+	}
+	*d = Device{
+		internalIP: dj.InternalIP,
+		pinnedIP:   dj.PinnedIP,
+		bootID:     idOrUnknown(dj.BootID),
+		configID:   idOrUnknown(dj.ConfigID),
+		client:     client,
+	}
+	return nil
+}
+
+// Validate confirms that the Device still responds, and that this host still
+// has its internal IP on the router's network.
+func (d Device) Validate(ctx context.Context) error {
+	ips, err := localIPs(d.Location())
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, ip := range ips {
+		found = found || ip == d.internalIP
+	}
+	if !found {
+		return errors.New("internal IP has changed")
+	}
+	_, err = d.ExternalIP(ctx)
+	return err
+}
@@ -0,0 +1,134 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+
+	"lukechampine.com/upnp/internal/goupnp"
+)
+
+// A ForwardPolicy determines what Forward does when the port is already
+// mapped.
+type ForwardPolicy int
+
+// Forward policies.
+const (
+	// ForwardDefault adds the mapping without checking for an existing one,
+	// returning whatever error the router reports. Routers typically
+	// overwrite mappings to the same client and reject mappings to others.
+	ForwardDefault ForwardPolicy = iota
+	// ForwardSkipIdentical does nothing if an identical mapping (ignoring its
+	// remaining lease) already exists, and otherwise behaves like
+	// ForwardDefault. It avoids needlessly rewriting the router's table, but
+	// does not renew leases; mappings with a lease are always re-added.
+	ForwardSkipIdentical
+	// ForwardOverwriteOwn replaces an existing mapping only if it is ours,
+	// i.e. it points at the same internal client and, if the description is
+	// tagged via WithOwner, belongs to the same App. Otherwise, a
+	// *ConflictError is returned without modifying the router's table.
+	ForwardOverwriteOwn
+	// ForwardFailFast returns a *ConflictError if any mapping for the port
+	// exists, even one of ours.
+	ForwardFailFast
+	// ForwardReplace deletes any existing mapping for the port, regardless of
+	// who created it, before adding the new one.
+	ForwardReplace
+)
+
+// String implements fmt.Stringer.
+func (p ForwardPolicy) String() string {
+	switch p {
+	case ForwardDefault:
+		return "default"
+	case ForwardSkipIdentical:
+		return "skip-identical"
+	case ForwardOverwriteOwn:
+		return "overwrite-own"
+	case ForwardFailFast:
+		return "fail-fast"
+	case ForwardReplace:
+		return "replace"
+	default:
+		return "unknown"
+	}
+}
+
+// WithPolicy sets the behavior of Forward when the port is already mapped.
+// If the router does not support querying individual mappings, the policy
+// cannot be enforced, and Forward behaves as with ForwardDefault.
+func WithPolicy(p ForwardPolicy) ForwardOption {
+	return func(o *forwardOptions) { o.policy = p }
+}
+
+func forwardPolicy(opts []ForwardOption) ForwardPolicy {
+	var o forwardOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.policy
+}
+
+// isOurs reports whether the existing mapping m was created by the same
+// client (and owner, if any) as req.
+func isOurs(m PortMapping, req goupnp.AddPortMappingRequest) bool {
+	if m.InternalClient != req.NewInternalClient {
+		return false
+	}
+	want, ok := MappingOwner(req.NewPortMappingDescription)
+	if !ok {
+		return true
+	}
+	have, ok := MappingOwner(m.Description)
+	return ok && have.App == want.App
+}
+
+// isIdentical reports whether the existing mapping m is equivalent to req.
+func isIdentical(m PortMapping, req goupnp.AddPortMappingRequest) bool {
+	return req.NewLeaseDuration == 0 && m.Lease == 0 &&
+		m.Enabled == req.NewEnabled &&
+		m.InternalClient == req.NewInternalClient &&
+		m.InternalPort == req.NewInternalPort &&
+		m.Description == req.NewPortMappingDescription
+}
+
+// forwardWithPolicy adds req, first checking for an existing mapping as
+// dictated by p.
+func (d Device) forwardWithPolicy(ctx context.Context, req goupnp.AddPortMappingRequest, p ForwardPolicy) error {
+	port, proto := req.NewExternalPort, req.NewProtocol
+	if p == ForwardReplace {
+		if err := d.Clear(ctx, port, proto); err != nil {
+			return err
+		}
+		return d.client.AddPortMapping(ctx, req)
+	}
+
+	m, err := d.specificMapping(ctx, port, proto)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrNoSuchEntryInArray) {
+		// can't tell what's there; leave it to the router
+		return d.client.AddPortMapping(ctx, req)
+	}
+	conflict := func() error {
+		return &ConflictError{Port: port, Protocol: proto, Existing: m, Err: ErrConflictInMappingEntry}
+	}
+	switch {
+	case !exists:
+	case p == ForwardSkipIdentical && isIdentical(m, req):
+		return nil
+	case p == ForwardFailFast:
+		return conflict()
+	case p == ForwardOverwriteOwn && !isOurs(m, req):
+		return conflict()
+	case p == ForwardOverwriteOwn:
+		// some routers refuse to overwrite even their own mappings
+		err := d.client.AddPortMapping(ctx, req)
+		if errors.Is(err, ErrConflictInMappingEntry) {
+			if err := d.Clear(ctx, port, proto); err != nil {
+				return err
+			}
+			err = d.client.AddPortMapping(ctx, req)
+		}
+		return err
+	}
+	return d.client.AddPortMapping(ctx, req)
+}
@@ -0,0 +1,146 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"lukechampine.com/upnp/natpmp"
+	"lukechampine.com/upnp/pcp"
+)
+
+// A PortMapper is a protocol-agnostic interface for forwarding ports and
+// discovering the gateway's external IP.
+type PortMapper interface {
+	// Forward forwards the specified port for the specified protocol, which
+	// must be "TCP" or "UDP". Some protocols do not support descriptions, in
+	// which case desc is ignored.
+	Forward(ctx context.Context, port uint16, proto string, desc string) error
+	// Renew refreshes a mapping previously created with Forward. Depending on
+	// the protocol, mappings may expire unless periodically renewed.
+	Renew(ctx context.Context, port uint16, proto string) error
+	// Clear un-forwards a port.
+	Clear(ctx context.Context, port uint16, proto string) error
+	// ExternalIP returns the gateway's external IP.
+	ExternalIP(ctx context.Context) (string, error)
+}
+
+var (
+	_ PortMapper = (*DeviceMapper)(nil)
+	_ PortMapper = (*natpmp.Client)(nil)
+	_ PortMapper = (*pcp.Client)(nil)
+)
+
+type mappingKey struct {
+	port  uint16
+	proto string
+}
+
+// A DeviceMapper adapts a Device to the PortMapper interface. Mappings created
+// via a DeviceMapper are permanent.
+type DeviceMapper struct {
+	Device Device
+
+	mu    sync.Mutex
+	descs map[mappingKey]string
+}
+
+// Forward implements PortMapper.
+func (dm *DeviceMapper) Forward(ctx context.Context, port uint16, proto string, desc string) error {
+	if err := dm.Device.Forward(ctx, port, proto, desc); err != nil {
+		return err
+	}
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.descs == nil {
+		dm.descs = make(map[mappingKey]string)
+	}
+	dm.descs[keyFor(port, proto)] = desc
+	return nil
+}
+
+// Renew implements PortMapper. Since the mapping is permanent, renewing it
+// only has an effect if the router has dropped it, e.g. after rebooting.
+func (dm *DeviceMapper) Renew(ctx context.Context, port uint16, proto string) error {
+	dm.mu.Lock()
+	desc := dm.descs[keyFor(port, proto)]
+	dm.mu.Unlock()
+	return dm.Device.Forward(ctx, port, proto, desc)
+}
+
+// Clear implements PortMapper.
+func (dm *DeviceMapper) Clear(ctx context.Context, port uint16, proto string) error {
+	if err := dm.Device.Clear(ctx, port, proto); err != nil {
+		return err
+	}
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	delete(dm.descs, keyFor(port, proto))
+	return nil
+}
+
+// ExternalIP implements PortMapper.
+func (dm *DeviceMapper) ExternalIP(ctx context.Context) (string, error) {
+	return dm.Device.ExternalIP(ctx)
+}
+
+// AutoDiscover probes the local network for gateways supporting UPnP, PCP, and
+// NAT-PMP concurrently, returning a PortMapper for the best protocol available.
+// UPnP is preferred, followed by PCP and then NAT-PMP. If a UPnP gateway is
+// found, the returned PortMapper is a *DeviceMapper.
+func AutoDiscover(ctx context.Context) (PortMapper, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	probes := []struct {
+		name string
+		fn   func() (PortMapper, error)
+	}{
+		{"UPnP", func() (PortMapper, error) {
+			d, err := Discover(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return &DeviceMapper{Device: d}, nil
+		}},
+		{"PCP", func() (PortMapper, error) { return pcp.Discover(ctx) }},
+		{"NAT-PMP", func() (PortMapper, error) { return natpmp.Discover(ctx) }},
+	}
+	type result struct {
+		pm   PortMapper
+		err  error
+		done bool
+	}
+	type indexedResult struct {
+		i int
+		result
+	}
+	results := make([]result, len(probes))
+	ch := make(chan indexedResult, len(probes))
+	for i := range probes {
+		go func(i int) {
+			pm, err := probes[i].fn()
+			ch <- indexedResult{i, result{pm, err, true}}
+		}(i)
+	}
+	for range probes {
+		r := <-ch
+		results[r.i] = r.result
+		// return the most-preferred success, unless a more-preferred probe is
+		// still pending
+		for _, r := range results {
+			if !r.done {
+				break
+			} else if r.err == nil {
+				return r.pm, nil
+			}
+		}
+	}
+	errs := make([]string, len(probes))
+	for i, r := range results {
+		errs[i] = fmt.Sprintf("%v: %v", probes[i].name, r.err)
+	}
+	return nil, errors.New("no gateway found (" + strings.Join(errs, "; ") + ")")
+}
@@ -0,0 +1,180 @@
+package upnp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"lukechampine.com/upnp/igd"
+	"lukechampine.com/upnp/internal/goupnp"
+	"lukechampine.com/upnp/internal/safexml"
+)
+
+// A DeviceProtection controls access to a router that supports the
+// DeviceProtection:1 service. Such routers may restrict actions like
+// AddPortMapping to control points that have logged in, in which case they
+// fail with ErrActionNotAuthorized.
+//
+// DeviceProtection:1 binds a login to the identity of the control point,
+// which is the client certificate it presents over TLS; see
+// WithClientCertificate. Routers that lack a certificate to go by typically
+// bind the login to the connection instead. To accommodate both, the
+// DeviceProtection performs its actions over a single dedicated connection
+// (where the Device's HTTP client permits), which actions performed via the
+// Device returned by its Device method share. If the router closes that
+// connection, such routers forget the login; actions then fail with
+// ErrActionNotAuthorized, and Login must be called again.
+type DeviceProtection struct {
+	client goupnp.IGDClient
+	d      Device
+}
+
+// ProtectionProtocols lists the protocols supported by a DeviceProtection
+// service.
+type ProtectionProtocols struct {
+	// Introduction lists the protocols that can be passed to
+	// SendSetupMessage, e.g. "WPS".
+	Introduction []string `xml:"Introduction>Name"`
+	// Login lists the protocols that can be used to log in, e.g. "PKCS5".
+	Login []string `xml:"Login>Name"`
+}
+
+// DeviceProtection returns the DeviceProtection service of the device.
+func (d Device) DeviceProtection(ctx context.Context) (DeviceProtection, error) {
+	d.client = d.client.WithHTTPClient(singleConnClient(d.client.HTTPClient()))
+	c, err := d.serviceClient(ctx, igd.DeviceProtection1)
+	if err != nil {
+		return DeviceProtection{}, err
+	}
+	return DeviceProtection{c, d}, nil
+}
+
+// Device returns a copy of the Device that shares the DeviceProtection's
+// connection, so that its actions are performed as the logged-in user.
+func (p DeviceProtection) Device() Device {
+	return p.d
+}
+
+// singleConnClient returns a copy of client that uses at most one connection
+// per host, so that every request reaches the device via the same connection
+// for as long as the device keeps it open. If client's transport is not an
+// *http.Transport, client is returned unmodified.
+func singleConnClient(client *http.Client) *http.Client {
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return client
+	}
+	t = t.Clone()
+	t.MaxConnsPerHost = 1
+	t.MaxIdleConnsPerHost = 1
+	cc := *client
+	cc.Transport = t
+	return &cc
+}
+
+// SupportedProtocols returns the introduction and login protocols supported by
+// the router.
+func (p DeviceProtection) SupportedProtocols(ctx context.Context) (ProtectionProtocols, error) {
+	resp, err := p.client.GetSupportedProtocols(ctx)
+	if err != nil {
+		return ProtectionProtocols{}, err
+	}
+	var pp ProtectionProtocols
+	if err := safexml.Unmarshal([]byte(resp.ProtocolList), &pp); err != nil {
+		return ProtectionProtocols{}, fmt.Errorf("invalid protocol list: %w", err)
+	}
+	return pp, nil
+}
+
+// SendSetupMessage sends one message of an introduction protocol (typically
+// "WPS") to the router, returning its reply. Introduction protocols consist of
+// several such exchanges, the contents of which are defined by the protocol;
+// callers are responsible for constructing and interpreting them.
+func (p DeviceProtection) SendSetupMessage(ctx context.Context, protocol string, msg []byte) ([]byte, error) {
+	resp, err := p.client.SendSetupMessage(ctx, goupnp.SendSetupMessageRequest{
+		ProtocolType: protocol,
+		InMessage:    base64.StdEncoding.EncodeToString(msg),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := base64.StdEncoding.DecodeString(resp.OutMessage)
+	if err != nil {
+		return nil, fmt.Errorf("invalid setup message: %w", err)
+	}
+	return out, nil
+}
+
+// Login logs in to the router with the specified name and password, using the
+// "PKCS5" login protocol. Subsequent actions must be performed via the Device
+// returned by p.Device.
+func (p DeviceProtection) Login(ctx context.Context, name, password string) error {
+	resp, err := p.client.GetUserLoginChallenge(ctx, goupnp.GetUserLoginChallengeRequest{
+		ProtocolType: "PKCS5",
+		Name:         name,
+	})
+	if err != nil {
+		return err
+	}
+	salt, err := base64.StdEncoding.DecodeString(resp.Salt)
+	if err != nil {
+		return fmt.Errorf("invalid login salt: %w", err)
+	}
+	challenge, err := base64.StdEncoding.DecodeString(resp.Challenge)
+	if err != nil {
+		return fmt.Errorf("invalid login challenge: %w", err)
+	}
+	return p.client.UserLogin(ctx, goupnp.UserLoginRequest{
+		ProtocolType:  "PKCS5",
+		Challenge:     resp.Challenge,
+		Authenticator: base64.StdEncoding.EncodeToString(loginAuthenticator(name, password, salt, challenge)),
+	})
+}
+
+// Logout ends the current login session.
+func (p DeviceProtection) Logout(ctx context.Context) error {
+	return p.client.UserLogout(ctx)
+}
+
+// loginAuthenticator computes the Authenticator argument of UserLogin, as
+// defined by the DeviceProtection:1 specification:
+//
+//	STORED = PBKDF2(SHA-256, Name || Password, Salt, 5000 iterations, 16 bytes)
+//	Authenticator = first 20 bytes of SHA-256(STORED || Challenge)
+func loginAuthenticator(name, password string, salt, challenge []byte) []byte {
+	stored := pbkdf2SHA256([]byte(name+password), salt, 5000, 16)
+	h := sha256.New()
+	h.Write(stored)
+	h.Write(challenge)
+	return h.Sum(nil)[:20]
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 8018) with HMAC-SHA-256.
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var dk []byte
+	for block := uint32(1); len(dk) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
+}
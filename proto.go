@@ -0,0 +1,39 @@
+package upnp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Proto is a transport protocol for which ports can be forwarded: TCP or
+// UDP. Methods that accept a protocol also accept lowercase (or mixed-case)
+// names, normalizing them before they are sent to the router, since many
+// routers reject anything else with a cryptic Invalid Args fault.
+type Proto = string
+
+// Supported protocols.
+const (
+	TCP Proto = "TCP"
+	UDP Proto = "UDP"
+)
+
+// ErrInvalidProtocol is returned when a protocol other than TCP or UDP is
+// specified.
+var ErrInvalidProtocol = errors.New("invalid protocol")
+
+// ParseProto normalizes proto to TCP or UDP. Any other protocol yields an error
+// wrapping ErrInvalidProtocol.
+func ParseProto(proto string) (Proto, error) {
+	switch p := strings.ToUpper(strings.TrimSpace(proto)); p {
+	case TCP, UDP:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w %q: must be TCP or UDP", ErrInvalidProtocol, proto)
+	}
+}
+
+// keyFor returns the key of the mapping for the specified port and protocol.
+func keyFor(port uint16, proto string) mappingKey {
+	return mappingKey{port, strings.ToUpper(strings.TrimSpace(proto))}
+}
@@ -0,0 +1,18 @@
+package upnp
+
+import "lukechampine.com/upnp/internal/goupnp"
+
+// Quirks describes the ways in which a router deviates from the UPnP spec.
+// Quirks are detected automatically from the router's SERVER header and model
+// name; additional quirks can be specified via WithQuirks.
+//
+// If NumericBooleans is set, boolean arguments are sent as "1" and "0". If
+// UppercaseSOAPAction is set, the SOAPACTION header name is sent in uppercase.
+// If PermanentLeasesOnly is set, mappings are created without a lease unless
+// the Device was created with WithStrictLeases.
+type Quirks = goupnp.Quirks
+
+// Quirks returns the quirks that apply to the Device, for diagnostic purposes.
+func (d Device) Quirks() Quirks {
+	return d.client.Quirks()
+}
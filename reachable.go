@@ -0,0 +1,115 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// A Prober checks whether a port is reachable from the internet. Probers are
+// supplied by the caller, since doing so requires a vantage point outside the
+// local network, e.g. an echo service or a reflector run by the application's
+// developers. The application must be listening on the port while it is
+// probed.
+type Prober interface {
+	// Probe attempts to reach addr via proto ("TCP" or "UDP") from outside the
+	// local network, returning nil if it succeeds.
+	Probe(ctx context.Context, addr netip.AddrPort, proto string) error
+}
+
+// A ProberFunc is a function that implements Prober.
+type ProberFunc func(ctx context.Context, addr netip.AddrPort, proto string) error
+
+// Probe implements Prober.
+func (fn ProberFunc) Probe(ctx context.Context, addr netip.AddrPort, proto string) error {
+	return fn(ctx, addr, proto)
+}
+
+// An HTTPProber asks a reflector service to probe the address. The reflector
+// receives a GET request for URL, with "addr" (host:port) and "proto" added to
+// its query string, and should respond with a 2xx status if it could reach the
+// address, and any other status (ideally with an explanatory body) if not.
+type HTTPProber struct {
+	URL string
+	// Client is used to contact the reflector. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+}
+
+// Probe implements Prober.
+func (p HTTPProber) Probe(ctx context.Context, addr netip.AddrPort, proto string) error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("addr", addr.String())
+	q.Set("proto", proto)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return fmt.Errorf("reflector reported %v: %v", resp.Status, msg)
+		}
+		return fmt.Errorf("reflector reported %v", resp.Status)
+	}
+	return nil
+}
+
+// An UnreachableError is returned by VerifyReachable when a port could not be
+// reached from the internet.
+type UnreachableError struct {
+	Addr     netip.AddrPort
+	Protocol string
+	Err      error
+}
+
+// Error implements error.
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%v %v is not reachable: %v", e.Protocol, e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyReachable confirms that the specified external port is actually
+// reachable from the internet, rather than merely present in the router's
+// mapping table, by asking p to probe it at the router's external IP. If the
+// probe fails, an *UnreachableError is returned. Typical causes include an
+// upstream NAT (see ExternalIPInfo and CheckExternalIP), an ISP or router
+// firewall, and routers that accept mappings without applying them.
+func (d Device) VerifyReachable(ctx context.Context, port uint16, proto string, p Prober) error {
+	proto, err := ParseProto(proto)
+	if err != nil {
+		return err
+	}
+	ip, err := d.ExternalAddr(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get external IP: %w", err)
+	}
+	addr := netip.AddrPortFrom(ip.Unmap(), port)
+	if err := p.Probe(ctx, addr, proto); err != nil {
+		return &UnreachableError{Addr: addr, Protocol: proto, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// A ReconcileEventType indicates what a Reconciler did to a mapping.
+type ReconcileEventType int
+
+// Reconcile event types.
+const (
+	// MappingAdded indicates that a desired mapping was created.
+	MappingAdded ReconcileEventType = iota
+	// MappingRenewed indicates that a desired mapping's lease was renewed.
+	MappingRenewed
+	// MappingLost indicates that a mapping previously created by the
+	// Reconciler disappeared from the router, e.g. because the router
+	// rebooted. The Reconciler will attempt to re-add it.
+	MappingLost
+	// MappingRemoved indicates that a mapping was cleared because it is no
+	// longer desired.
+	MappingRemoved
+	// MappingFailed indicates that an operation on a mapping failed.
+	MappingFailed
+)
+
+// String implements fmt.Stringer.
+func (t ReconcileEventType) String() string {
+	switch t {
+	case MappingAdded:
+		return "added"
+	case MappingRenewed:
+		return "renewed"
+	case MappingLost:
+		return "lost"
+	case MappingRemoved:
+		return "removed"
+	case MappingFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// A ReconcileEvent reports an action taken by a Reconciler.
+type ReconcileEvent struct {
+	Type    ReconcileEventType
+	Mapping PortMapping
+	// Err is set for MappingFailed events.
+	Err error
+}
+
+// A Reconciler continuously converges a router's mapping table towards a
+// desired set of mappings. It re-adds mappings that the router drops, renews
+// leases before they expire, and removes mappings that it created but that are
+// no longer desired. Mappings that the Reconciler did not create are never
+// removed.
+type Reconciler struct {
+	d        Device // guarded by passMu
+	clock    Clock
+	interval time.Duration
+	events   chan ReconcileEvent
+	trigger  chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	desired map[mappingKey]PortMapping
+	closed  bool
+	watchIP bool
+
+	passMu  sync.Mutex // serializes reconciliation passes
+	managed map[mappingKey]PortMapping
+}
+
+// NewReconciler returns a Reconciler that reconciles the Device's mappings
+// every interval, or more frequently if required to renew leases. The
+// Reconciler initially desires no mappings; use SetDesired to specify them.
+func NewReconciler(d Device, interval time.Duration) *Reconciler {
+	r := &Reconciler{
+		d:        d,
+		clock:    d.client.Clock(),
+		interval: interval,
+		events:   make(chan ReconcileEvent, 64),
+		trigger:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		desired:  make(map[mappingKey]PortMapping),
+		managed:  make(map[mappingKey]PortMapping),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	go r.run()
+	return r
+}
+
+// Events returns a channel that receives the Reconciler's events. Events are
+// dropped if the channel's buffer is full.
+func (r *Reconciler) Events() <-chan ReconcileEvent {
+	return r.events
+}
+
+// SetDesired replaces the set of desired mappings and triggers a
+// reconciliation pass. For each mapping, ExternalPort, Protocol, and
+// Description must be set; InternalPort defaults to ExternalPort, and
+// InternalClient defaults to this host. A non-zero Lease requests a leased
+// mapping, which will be renewed automatically. Enabled is ignored.
+func (r *Reconciler) SetDesired(ms []PortMapping) {
+	desired := make(map[mappingKey]PortMapping, len(ms))
+	for _, m := range ms {
+		if m.InternalPort == 0 {
+			m.InternalPort = m.ExternalPort
+		}
+		// NOTE: an empty InternalClient is resolved during each pass, so that
+		// it tracks changes to the internal IP
+		m.Enabled = true
+		key := keyFor(m.ExternalPort, m.Protocol)
+		m.Protocol = key.proto
+		desired[key] = m
+	}
+	r.mu.Lock()
+	r.desired = desired
+	r.mu.Unlock()
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// WatchInternalIP causes each subsequent reconciliation pass to first
+// re-detect this host's internal IP, as in Device.WithRefreshedInternalIP. When it
+// changes, desired mappings that default to this host are re-pointed at the
+// new address, replacing the mappings created for the old one. This keeps
+// mappings working on hosts whose address changes, e.g. laptops roaming
+// between networks.
+func (r *Reconciler) WatchInternalIP() {
+	r.mu.Lock()
+	r.watchIP = true
+	r.mu.Unlock()
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Reconciler) emit(t ReconcileEventType, m PortMapping, err error) {
+	select {
+	case r.events <- ReconcileEvent{t, m, err}:
+	default:
+	}
+}
+
+func (r *Reconciler) add(ctx context.Context, m PortMapping) error {
+	return r.d.Forward(ctx, m.ExternalPort, m.Protocol, m.Description,
+		WithInternalPort(m.InternalPort), WithInternalClient(m.InternalClient), WithLease(m.Lease))
+}
+
+// Reconcile performs a single reconciliation pass.
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	r.passMu.Lock()
+	defer r.passMu.Unlock()
+	r.mu.Lock()
+	desired := r.desired
+	watchIP := r.watchIP
+	r.mu.Unlock()
+
+	var firstErr error
+	fail := func(m PortMapping, err error) {
+		r.emit(MappingFailed, m, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if watchIP {
+		if d, changed, err := r.d.WithRefreshedInternalIP(); err != nil && firstErr == nil {
+			firstErr = err
+		} else if changed {
+			r.d = d
+		}
+	}
+	for key, m := range desired {
+		if m.InternalClient == "" {
+			m.InternalClient = r.d.internalIP
+		}
+		s, err := r.d.MappingStatus(ctx, m.ExternalPort, m.Protocol)
+		if err != nil {
+			fail(m, err)
+			continue
+		}
+		prev, managed := r.managed[key]
+		ours := s.Exists && s.InternalClient == m.InternalClient && s.InternalPort == m.InternalPort
+		switch {
+		case !ours:
+			if managed && !s.Exists {
+				r.emit(MappingLost, m, nil)
+			} else if managed && s.InternalClient == prev.InternalClient && s.InternalPort == prev.InternalPort {
+				// we created the existing mapping, but it now points at the
+				// wrong address; many routers refuse to overwrite it
+				if err := r.d.Clear(ctx, m.ExternalPort, m.Protocol); err != nil {
+					fail(m, err)
+					continue
+				}
+			}
+			if err := r.add(ctx, m); err != nil {
+				fail(m, err)
+				continue
+			}
+			r.emit(MappingAdded, m, nil)
+		case m.Lease > 0 && s.Lease > 0 && s.Lease <= m.Lease/2:
+			// NOTE: a remaining lease of 0 means the mapping is permanent,
+			// e.g. because the router only supports permanent leases, so
+			// there is nothing to renew
+			if err := r.add(ctx, m); err != nil {
+				fail(m, err)
+				continue
+			}
+			r.emit(MappingRenewed, m, nil)
+		default:
+			if !managed {
+				// the mapping already existed; since we didn't create it, we
+				// must not remove it either
+				continue
+			}
+		}
+		r.managed[key] = m
+	}
+	for key, m := range r.managed {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if err := r.d.Clear(ctx, m.ExternalPort, m.Protocol); err != nil {
+			fail(m, err)
+			continue
+		}
+		delete(r.managed, key)
+		r.emit(MappingRemoved, m, nil)
+	}
+	return firstErr
+}
+
+// nextPass returns how long to wait before the next reconciliation pass.
+func (r *Reconciler) nextPass() time.Duration {
+	next := r.interval
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.desired {
+		// check frequently enough to renew leases well before they expire
+		if m.Lease > 0 && m.Lease/4 < next {
+			next = m.Lease / 4
+		}
+	}
+	if next < time.Second {
+		next = time.Second
+	}
+	return next
+}
+
+func (r *Reconciler) run() {
+	defer close(r.done)
+	for {
+		ctx, cancel := context.WithTimeout(r.ctx, r.nextPass())
+		r.Reconcile(ctx)
+		cancel()
+		timer := r.clock.NewTimer(r.nextPass())
+		select {
+		case <-r.trigger:
+		case <-timer.C():
+		case <-r.ctx.Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// Close stops the Reconciler and clears every mapping it created.
+func (r *Reconciler) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return errors.New("reconciler already closed")
+	}
+	r.closed = true
+	r.mu.Unlock()
+	r.cancel()
+	<-r.done
+
+	r.passMu.Lock()
+	defer r.passMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var firstErr error
+	for key, m := range r.managed {
+		if err := r.d.Clear(ctx, m.ExternalPort, m.Protocol); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(r.managed, key)
+	}
+	return firstErr
+}
@@ -0,0 +1,15 @@
+//go:build !plan9
+// +build !plan9
+
+package upnp
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isConnRefused reports whether err indicates that the remote host actively
+// refused a connection.
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
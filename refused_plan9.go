@@ -0,0 +1,9 @@
+package upnp
+
+import "strings"
+
+// isConnRefused reports whether err indicates that the remote host actively
+// refused a connection. Plan 9 reports errors as strings.
+func isConnRefused(err error) bool {
+	return strings.Contains(err.Error(), "connection refused")
+}
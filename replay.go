@@ -0,0 +1,174 @@
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Replay is an http.RoundTripper that serves the HTTP responses recorded in
+// a Capture, allowing a router's behavior (including its bugs) to be
+// reproduced without the router itself. Captures of problematic routers can
+// thus be contributed as test fixtures.
+//
+// Requests are matched to recorded exchanges by method, path, and SOAP action.
+// Among the exchanges that match, one with an identical body is preferred;
+// otherwise, the exchanges are replayed in the order they were recorded. Once
+// every match has been served, the last one is served again. Requests with no
+// match fail.
+type Replay struct {
+	location   string
+	internalIP string
+
+	mu      sync.Mutex
+	entries []replayEntry
+}
+
+type replayEntry struct {
+	key  string
+	body []byte
+	resp []byte
+	used bool
+}
+
+func replayKey(method, path, soapAction string) string {
+	return method + " " + path + " " + strings.Trim(soapAction, `"`)
+}
+
+// splitMessage splits a recorded HTTP message into its header and body.
+func splitMessage(msg []byte) (head, body []byte) {
+	for _, sep := range []string{"\r\n\r\n", "\n\n"} {
+		if i := bytes.Index(msg, []byte(sep)); i >= 0 {
+			return msg[:i+len(sep)], msg[i+len(sep):]
+		}
+	}
+	return append(msg, "\r\n\r\n"...), nil
+}
+
+var internalClientRE = regexp.MustCompile(`<NewInternalClient>([0-9.]+)</NewInternalClient>`)
+
+// NewReplay returns a Replay that serves the HTTP exchanges recorded in c.
+func NewReplay(c *Capture) (*Replay, error) {
+	r := new(Replay)
+	for _, e := range c.Exchanges() {
+		if e.Protocol != "HTTP" || e.Request == nil || e.Response == nil {
+			continue
+		}
+		head, body := splitMessage(e.Request)
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid request recorded at %v: %w", e.Time, err)
+		}
+		if r.location == "" && req.Method == "GET" {
+			r.location = "http://" + e.Remote + req.URL.RequestURI()
+		}
+		if m := internalClientRE.FindSubmatch(body); m != nil && r.internalIP == "" {
+			r.internalIP = string(m[1])
+		}
+		r.entries = append(r.entries, replayEntry{
+			key:  replayKey(req.Method, req.URL.Path, req.Header.Get("SOAPAction")),
+			body: bytes.TrimSpace(body),
+			resp: e.Response,
+		})
+	}
+	if r.location == "" {
+		return nil, errors.New("capture does not contain a device description")
+	}
+	return r, nil
+}
+
+// Location returns the URL of the first device description in the capture,
+// suitable for passing to Connect.
+func (r *Replay) Location() string {
+	return r.location
+}
+
+// Client returns an *http.Client that uses r as its transport.
+func (r *Replay) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// match returns the recorded response for req. r.mu must be held.
+func (r *Replay) match(key string, body []byte) []byte {
+	var first, last *replayEntry
+	for i := range r.entries {
+		e := &r.entries[i]
+		if e.key != key {
+			continue
+		}
+		if !e.used && bytes.Equal(e.body, body) {
+			e.used = true
+			return e.resp
+		}
+		if !e.used && first == nil {
+			first = e
+		}
+		last = e
+	}
+	if first != nil {
+		first.used = true
+		return first.resp
+	} else if last != nil {
+		return last.resp
+	}
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replay) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	key := replayKey(req.Method, req.URL.Path, req.Header.Get("SOAPAction"))
+	r.mu.Lock()
+	recorded := r.match(key, bytes.TrimSpace(body))
+	r.mu.Unlock()
+	if recorded == nil {
+		return nil, fmt.Errorf("no recorded response for %v", key)
+	}
+
+	head, respBody := splitMessage(recorded)
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), req)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded response for %v: %w", key, err)
+	}
+	for _, te := range resp.TransferEncoding {
+		if te == "chunked" {
+			// the trailing CRLFs were trimmed when the capture was written
+			respBody, err = ioutil.ReadAll(httputil.NewChunkedReader(bytes.NewReader(append(respBody, "\r\n"...))))
+			if err != nil {
+				return nil, fmt.Errorf("invalid recorded response for %v: %w", key, err)
+			}
+		}
+	}
+	// likewise, trimming may have invalidated the Content-Length
+	resp.TransferEncoding = nil
+	resp.Header.Del("Transfer-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(respBody)))
+	resp.ContentLength = int64(len(respBody))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// WithReplay serves every HTTP request from r rather than the network,
+// overriding WithHTTPClient. Unless WithInternalIP is also supplied, the
+// resulting Devices use the internal IP found in the capture, if any. A
+// Device for the captured router can be obtained via Connect with
+// r.Location().
+func WithReplay(r *Replay) Option {
+	return func(o *options) { o.replay = r }
+}
@@ -0,0 +1,25 @@
+package upnp
+
+import (
+	"context"
+
+	"lukechampine.com/upnp/igd"
+)
+
+// ServiceDescription fetches the description of the Device's WAN connection
+// service, which lists the actions and state variables it supports.
+func (d Device) ServiceDescription(ctx context.Context) (igd.SCPD, error) {
+	return d.client.SCPD(ctx)
+}
+
+// SupportsAction reports whether the Device's WAN connection service declares
+// the specified action, e.g. "AddAnyPortMapping". Note that some routers
+// declare actions they don't actually implement, and vice versa.
+func (d Device) SupportsAction(ctx context.Context, action string) (bool, error) {
+	scpd, err := d.client.SCPD(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := scpd.Action(action)
+	return ok, nil
+}
@@ -0,0 +1,116 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"lukechampine.com/upnp/igd"
+)
+
+// A SelectionStrategy is a criterion for choosing between several gateways.
+type SelectionStrategy int
+
+// Selection strategies.
+const (
+	// PreferDefaultRoute prefers gateways reachable via the interface that the
+	// OS uses for its default route.
+	PreferDefaultRoute SelectionStrategy = iota
+	// PreferPublicIP prefers gateways that report a public external IP, i.e.
+	// gateways that are not behind another NAT.
+	PreferPublicIP
+	// PreferIGDv2 prefers gateways offering version 2 (or later) of their WAN
+	// connection service.
+	PreferIGDv2
+	// PreferLowestLatency prefers the gateway that responds most quickly.
+	PreferLowestLatency
+)
+
+// DefaultSelectionStrategies are the strategies used by SelectGateway if none
+// are specified.
+var DefaultSelectionStrategies = []SelectionStrategy{
+	PreferPublicIP,
+	PreferDefaultRoute,
+	PreferIGDv2,
+	PreferLowestLatency,
+}
+
+// defaultRouteIP returns the local IP address of the interface used for the
+// default route. No packets are sent.
+func defaultRouteIP() string {
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	return host
+}
+
+type candidate struct {
+	d       Device
+	public  bool
+	latency time.Duration
+	err     error
+}
+
+// SelectGateway chooses the best of several gateways, e.g. those returned by
+// DiscoverAll on a network with multiple routers. The strategies are applied
+// in order, each narrowing the set of candidates; a strategy that no candidate
+// satisfies is skipped. Gateways that fail to report an external IP are only
+// selected if every gateway fails.
+func SelectGateway(ctx context.Context, devices []Device, strategies ...SelectionStrategy) (Device, error) {
+	if len(devices) == 0 {
+		return Device{}, errors.New("no gateways to select from")
+	} else if len(strategies) == 0 {
+		strategies = DefaultSelectionStrategies
+	}
+
+	cands := make([]candidate, len(devices))
+	var wg sync.WaitGroup
+	for i := range devices {
+		wg.Add(1)
+		go func(c *candidate, d Device) {
+			defer wg.Done()
+			start := time.Now()
+			info, err := d.ExternalIPInfo(ctx)
+			*c = candidate{d, err == nil && info.Reachable(), time.Since(start), err}
+		}(&cands[i], devices[i])
+	}
+	wg.Wait()
+
+	narrow := func(keep func(candidate) bool) {
+		var kept []candidate
+		for _, c := range cands {
+			if keep(c) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) > 0 {
+			cands = kept
+		}
+	}
+	narrow(func(c candidate) bool { return c.err == nil })
+	for _, s := range strategies {
+		switch s {
+		case PreferDefaultRoute:
+			ip := defaultRouteIP()
+			narrow(func(c candidate) bool { return c.d.internalIP == ip })
+		case PreferPublicIP:
+			narrow(func(c candidate) bool { return c.public })
+		case PreferIGDv2:
+			narrow(func(c candidate) bool { return igd.ServiceVersion(c.d.client.ServiceType()) >= 2 })
+		case PreferLowestLatency:
+			best := cands[0]
+			for _, c := range cands[1:] {
+				if c.latency < best.latency {
+					best = c
+				}
+			}
+			cands = []candidate{best}
+		}
+	}
+	return cands[0].d, nil
+}
@@ -0,0 +1,257 @@
+// Package soap implements the SOAP-over-HTTP calls used to invoke UPnP
+// actions.
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"lukechampine.com/upnp/internal/safexml"
+)
+
+type reqAction struct {
+	name  string
+	space string
+	inner interface{}
+}
+
+func (a reqAction) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(a.inner, xml.StartElement{
+		Name: xml.Name{Local: a.name},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns:u"}, Value: a.space}},
+	})
+}
+
+type reqEnvelope struct {
+	XMLName       xml.Name `xml:"s:Envelope"`
+	Space         string   `xml:"xmlns:s,attr"`
+	EncodingStyle string   `xml:"s:encodingStyle,attr"`
+	Body          struct {
+		XMLName xml.Name `xml:"s:Body"`
+		Action  reqAction
+	}
+}
+
+type respEnvelope struct {
+	XMLName       xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	EncodingStyle string   `xml:"http://schemas.xmlsoap.org/soap/envelope/ encodingStyle,attr"`
+	Body          struct {
+		Fault *struct {
+			FaultCode   string `xml:"faultcode"`
+			FaultString string `xml:"faultstring"`
+			Detail      *struct {
+				UPnPError *struct {
+					Code        string `xml:"errorCode"`
+					Description string `xml:"errorDescription"`
+				} `xml:"UPnPError"`
+			} `xml:"detail"`
+		} `xml:"Fault"`
+		RawAction []byte `xml:",innerxml"`
+	} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+}
+
+// A UPnPError is a SOAP fault containing a UPnP error code.
+type UPnPError struct {
+	Code        int
+	Description string
+}
+
+// Error implements error.
+func (e *UPnPError) Error() string {
+	return fmt.Sprintf("UPnP error: %s (error code %d)", e.Description, e.Code)
+}
+
+// Is reports whether target is a *UPnPError with the same error code.
+func (e *UPnPError) Is(target error) bool {
+	t, ok := target.(*UPnPError)
+	return ok && t.Code == e.Code
+}
+
+// A Fault is a SOAP fault that does not contain a UPnP error code.
+type Fault struct {
+	FaultString string
+}
+
+// Error implements error.
+func (f *Fault) Error() string {
+	return "SOAP fault: " + f.FaultString
+}
+
+// An HTTPError is an HTTP error response that does not contain a SOAP fault.
+type HTTPError struct {
+	StatusCode int
+}
+
+// Error implements error.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP error: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// IsTransient reports whether err is likely to be resolved by retrying the
+// request, e.g. a refused connection, a timeout, or an HTTP 5xx response. SOAP
+// faults and UPnP errors are never transient.
+func IsTransient(err error) bool {
+	var fault *Fault
+	var upnpErr *UPnPError
+	var httpErr *HTTPError
+	var netErr net.Error
+	switch {
+	case err == nil, errors.As(err, &fault), errors.As(err, &upnpErr):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode >= 500
+	case errors.As(err, &netErr):
+		return true
+	default:
+		// the router dropped the connection mid-response
+		return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+}
+
+// Options modify how requests are encoded, to accommodate devices that deviate
+// from the spec.
+type Options struct {
+	// NumericBooleans encodes boolean arguments as "1" and "0" rather than
+	// "true" and "false". Only the top-level fields of the request struct
+	// are affected.
+	NumericBooleans bool
+	// UppercaseSOAPAction sends the SOAPACTION header name in uppercase rather
+	// than in Go's canonical form ("Soapaction").
+	UppercaseSOAPAction bool
+}
+
+// numericBools marshals the bool fields of a struct as "1" or "0".
+type numericBools struct {
+	v interface{}
+}
+
+func (n numericBools) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	v := reflect.Indirect(reflect.ValueOf(n.v))
+	if v.Kind() != reflect.Struct {
+		return e.EncodeElement(n.v, start)
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Name == "XMLName" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("xml"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fv := v.Field(i).Interface()
+		if b, ok := fv.(bool); ok {
+			fv = "0"
+			if b {
+				fv = "1"
+			}
+		}
+		if err := e.EncodeElement(fv, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func encodeRequest(actionNamespace string, actionName string, action interface{}) string {
+	if action == nil {
+		// actions without arguments must still be sent as an empty element
+		action = struct{}{}
+	}
+	e := reqEnvelope{
+		Space:         "http://schemas.xmlsoap.org/soap/envelope/",
+		EncodingStyle: "http://schemas.xmlsoap.org/soap/encoding/",
+	}
+	e.Body.Action = reqAction{
+		name:  "u:" + actionName,
+		space: actionNamespace,
+		inner: action,
+	}
+	b, _ := xml.Marshal(e)
+	return xml.Header + string(b)
+}
+
+// Call invokes the specified action of the service at the specified control
+// URL. The action's namespace is the service type, e.g.
+// "urn:schemas-upnp-org:service:WANIPConnection:1". req is marshaled as the
+// action's input arguments and the output arguments are unmarshaled into
+// resp, following the rules of encoding/xml; either may be nil.
+//
+// If the device responds with a fault, the returned error is a *UPnPError or
+// *Fault. If it responds with an HTTP error and no fault, the returned error is
+// an *HTTPError.
+func Call(ctx context.Context, client *http.Client, url string, actionNamespace, actionName string, req interface{}, resp interface{}) error {
+	return CallWithOptions(ctx, client, url, actionNamespace, actionName, req, resp, Options{})
+}
+
+// CallWithOptions is like Call, but encodes the request according to opts.
+func CallWithOptions(ctx context.Context, client *http.Client, url string, actionNamespace, actionName string, req interface{}, resp interface{}, opts Options) error {
+	if opts.NumericBooleans && req != nil {
+		req = numericBools{req}
+	}
+	requestBody := strings.NewReader(encodeRequest(actionNamespace, actionName, req))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, requestBody)
+	if err != nil {
+		return err
+	}
+	soapAction := fmt.Sprintf(`"%s#%s"`, actionNamespace, actionName)
+	if opts.UppercaseSOAPAction {
+		httpReq.Header["SOAPACTION"] = []string{soapAction}
+	} else {
+		httpReq.Header.Set("SOAPACTION", soapAction)
+	}
+	httpReq.Header.Set("CONTENT-TYPE", `text/xml; charset="utf-8"`)
+	httpReq.ContentLength = int64(requestBody.Len())
+	response, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	defer io.Copy(ioutil.Discard, io.LimitReader(response.Body, safexml.MaxSize))
+
+	var responseEnv respEnvelope
+	decoder, err := safexml.NewDecoder(response.Body)
+	if err == nil {
+		err = decoder.Decode(&responseEnv)
+	}
+	if err != nil {
+		if response.StatusCode != http.StatusOK {
+			return &HTTPError{StatusCode: response.StatusCode}
+		}
+		return fmt.Errorf("invalid response body: %w", err)
+	} else if f := responseEnv.Body.Fault; f != nil {
+		if f.Detail == nil || f.Detail.UPnPError == nil {
+			return &Fault{FaultString: f.FaultString}
+		}
+		e := f.Detail.UPnPError
+		code, err := strconv.Atoi(strings.TrimSpace(e.Code))
+		if err != nil {
+			return fmt.Errorf("invalid UPnP error code %q: %w", e.Code, err)
+		}
+		return &UPnPError{Code: code, Description: e.Description}
+	}
+	if resp != nil {
+		if err := safexml.Unmarshal(responseEnv.Body.RawAction, resp); err != nil {
+			return fmt.Errorf("invalid response body: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package ssdp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// packetPool holds buffers for receiving packets. SSDP packets are small, and
+// a long-lived listener receives a great many of them, so the buffers are
+// reused rather than allocated per socket.
+var packetPool = sync.Pool{
+	New: func() interface{} { return new([2048]byte) },
+}
+
+// A message is a parsed SSDP packet. Only the headers used by this package
+// are retained.
+type message struct {
+	// method is set for requests, e.g. "NOTIFY", and status for responses.
+	method string
+	status int
+
+	st, nt, nts, usn string
+	location, server string
+	cacheControl     string
+	bootID           string
+	nextBootID       string
+	configID         string
+}
+
+var errMalformed = errors.New("malformed SSDP message")
+
+// parseMessage parses an SSDP packet. SSDP messages are HTTP-like, but always
+// fit in a single packet and never have a body, so parsing them with net/http
+// is needlessly expensive.
+func parseMessage(b []byte) (message, error) {
+	var m message
+	line, rest := nextLine(b)
+	if bytes.HasPrefix(line, []byte("HTTP/")) {
+		// e.g. "HTTP/1.1 200 OK"
+		fields := bytes.Fields(line)
+		if len(fields) < 2 {
+			return message{}, errMalformed
+		}
+		code, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return message{}, errMalformed
+		}
+		m.status = code
+	} else {
+		// e.g. "NOTIFY * HTTP/1.1"
+		fields := bytes.Fields(line)
+		if len(fields) != 3 || !bytes.HasPrefix(fields[2], []byte("HTTP/")) {
+			return message{}, errMalformed
+		}
+		m.method = string(fields[0])
+	}
+	for len(rest) > 0 {
+		line, rest = nextLine(rest)
+		if len(line) == 0 {
+			break
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			return message{}, errMalformed
+		}
+		key, value := bytes.TrimSpace(line[:i]), bytes.TrimSpace(line[i+1:])
+		var dst *string
+		switch {
+		case bytes.EqualFold(key, []byte("ST")):
+			dst = &m.st
+		case bytes.EqualFold(key, []byte("NT")):
+			dst = &m.nt
+		case bytes.EqualFold(key, []byte("NTS")):
+			dst = &m.nts
+		case bytes.EqualFold(key, []byte("USN")):
+			dst = &m.usn
+		case bytes.EqualFold(key, []byte("LOCATION")):
+			dst = &m.location
+		case bytes.EqualFold(key, []byte("SERVER")):
+			dst = &m.server
+		case bytes.EqualFold(key, []byte("CACHE-CONTROL")):
+			dst = &m.cacheControl
+		case bytes.EqualFold(key, []byte("BOOTID.UPNP.ORG")):
+			dst = &m.bootID
+		case bytes.EqualFold(key, []byte("NEXTBOOTID.UPNP.ORG")):
+			dst = &m.nextBootID
+		case bytes.EqualFold(key, []byte("CONFIGID.UPNP.ORG")):
+			dst = &m.configID
+		default:
+			continue
+		}
+		// like net/http, use the first occurrence of each header
+		if *dst == "" {
+			*dst = string(value)
+		}
+	}
+	return m, nil
+}
+
+// nextLine splits b at the first line ending, which may be CRLF or (from
+// sloppy implementations) a bare LF.
+func nextLine(b []byte) (line, rest []byte) {
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return b, nil
+	}
+	line, rest = b[:i], b[i+1:]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, rest
+}
@@ -0,0 +1,628 @@
+// Package ssdp implements the Simple Service Discovery Protocol, which UPnP
+// devices use to advertise themselves on the local network.
+package ssdp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lukechampine.com/upnp/internal/logging"
+)
+
+var (
+	ssdpIPv4Group          = net.ParseIP("239.255.255.250")
+	ssdpIPv6LinkLocalGroup = net.ParseIP("ff02::c")
+	ssdpIPv6SiteLocalGroup = net.ParseIP("ff05::c")
+)
+
+const ssdpPort = 1900
+
+// A Logger receives debug messages describing the packets sent and received
+// during a search. Messages are accompanied by alternating keys and values, in
+// the style of log/slog; a *slog.Logger satisfies this interface.
+type Logger = logging.Logger
+
+// Options configures a search.
+type Options struct {
+	// Timeout is how long to wait for responses. The default is 2 seconds.
+	Timeout time.Duration
+	// SearchTarget is the ST of the search. The default is "upnp:rootdevice",
+	// unless SearchTargets is set.
+	SearchTarget string
+	// SearchTargets are additional STs to search for in the same pass. Each
+	// send transmits a separate M-SEARCH for each target. Note that a device
+	// typically responds to each target with a distinct USN, so the same
+	// location may be received more than once.
+	SearchTargets []string
+	// Sends is how many times the search is sent, to compensate for packet
+	// loss. The default is 3.
+	Sends int
+	// SendInterval is the delay between successive sends. The default is 5
+	// milliseconds. Spacing sends further apart improves the odds of a
+	// retransmit surviving a burst of packet loss, e.g. on congested Wi-Fi.
+	SendInterval time.Duration
+	// MX is the maximum number of seconds that devices may wait before
+	// responding, which they use to spread out their responses. It must be
+	// between 1 and 5. The default is Timeout, rounded down to whole
+	// seconds and clamped to that range. A lower MX yields faster responses
+	// at the risk of devices' responses colliding.
+	MX int
+	// ReadBuffer, if non-zero, sets the size of the receive buffer of each
+	// socket, which may need to be raised on networks with many devices to
+	// avoid dropping responses.
+	ReadBuffer int
+	// Interfaces restricts the search to the specified interfaces. By default,
+	// the operating system chooses the interface for IPv4, and IPv6 searches
+	// are sent on every multicast-capable interface.
+	Interfaces []net.Interface
+	// LocalAddrs binds the search to the specified local addresses, overriding
+	// Interfaces, IPv4, and IPv6.
+	LocalAddrs []net.IP
+	// IPv4 and IPv6 enable searching via the respective protocols. If neither
+	// is set, IPv4 is used.
+	IPv4 bool
+	IPv6 bool
+	// Addrs, if set, causes the search to be sent to the specified unicast
+	// addresses instead of the multicast groups, overriding all of the above.
+	Addrs []*net.UDPAddr
+	// Unicast, if set, causes the search to also be sent to the specified
+	// unicast addresses, alongside the multicast groups. Unicast searches
+	// omit MX, so devices respond to them immediately rather than after a
+	// random delay. Unicast is ignored if Addrs is set.
+	Unicast []*net.UDPAddr
+	// ListenConfig, if set, is used to create each socket, allowing the
+	// caller to set socket options (e.g. SO_REUSEADDR, the multicast TTL, or
+	// SO_BINDTODEVICE) via its Control function.
+	ListenConfig *net.ListenConfig
+	// Conn, if set, is used as the sole socket for the search, overriding
+	// ListenConfig, Interfaces, LocalAddrs, IPv4, and IPv6. The search is sent
+	// to Addrs if set, and otherwise to the IPv4 multicast group. The search
+	// takes ownership of Conn, closing it once the search completes.
+	Conn net.PacketConn
+	// Logger, if set, receives debug messages for each packet sent and
+	// received.
+	Logger Logger
+	// Trace, if set, is called with the raw contents of each packet sent and
+	// received. It may be called concurrently.
+	Trace func(sent bool, addr net.Addr, packet []byte)
+}
+
+type ssdpConn struct {
+	conn    net.PacketConn
+	groups  []*net.UDPAddr
+	unicast []*net.UDPAddr
+}
+
+// ssdpTarget is a local address to search from, along with the multicast
+// groups to send the search to.
+type ssdpTarget struct {
+	network string
+	laddr   string
+	groups  []*net.UDPAddr
+}
+
+func ipv4Target(ip net.IP) ssdpTarget {
+	// binding to the interface's address causes multicast packets to be sent
+	// via that interface
+	return ssdpTarget{"udp4", net.JoinHostPort(ip.String(), "0"), []*net.UDPAddr{{IP: ssdpIPv4Group, Port: ssdpPort}}}
+}
+
+func ipv6Target(ip net.IP, iface string) ssdpTarget {
+	host := ip.String()
+	if ip.IsLinkLocalUnicast() {
+		host += "%" + iface
+	}
+	return ssdpTarget{"udp6", net.JoinHostPort(host, "0"), []*net.UDPAddr{
+		{IP: ssdpIPv6LinkLocalGroup, Port: ssdpPort, Zone: iface},
+		{IP: ssdpIPv6SiteLocalGroup, Port: ssdpPort, Zone: iface},
+	}}
+}
+
+func ssdpTargets(opts Options) ([]ssdpTarget, error) {
+	if len(opts.Addrs) > 0 {
+		return []ssdpTarget{{"udp", ":0", opts.Addrs}}, nil
+	}
+	var targets []ssdpTarget
+	if len(opts.LocalAddrs) > 0 {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+	outer:
+		for _, ip := range opts.LocalAddrs {
+			if ip.To4() != nil {
+				targets = append(targets, ipv4Target(ip))
+				continue
+			}
+			// IPv6 multicast requires an interface
+			for _, iface := range ifaces {
+				addrs, err := iface.Addrs()
+				if err != nil {
+					return nil, err
+				}
+				for _, addr := range addrs {
+					if x, ok := addr.(*net.IPNet); ok && x.IP.Equal(ip) {
+						targets = append(targets, ipv6Target(ip, iface.Name))
+						continue outer
+					}
+				}
+			}
+			return nil, fmt.Errorf("no interface has address %v", ip)
+		}
+		return targets, nil
+	}
+
+	if opts.IPv4 {
+		if len(opts.Interfaces) == 0 {
+			targets = append(targets, ssdpTarget{"udp4", ":0", []*net.UDPAddr{{IP: ssdpIPv4Group, Port: ssdpPort}}})
+		}
+		for _, iface := range opts.Interfaces {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range addrs {
+				if x, ok := addr.(*net.IPNet); ok && x.IP.To4() != nil {
+					targets = append(targets, ipv4Target(x.IP))
+				}
+			}
+		}
+	}
+	if opts.IPv6 {
+		ifaces := opts.Interfaces
+		if len(ifaces) == 0 {
+			var err error
+			ifaces, err = MulticastInterfaces()
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, iface := range ifaces {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range addrs {
+				if x, ok := addr.(*net.IPNet); ok && x.IP.To4() == nil && x.IP.IsLinkLocalUnicast() {
+					targets = append(targets, ipv6Target(x.IP, iface.Name))
+					break
+				}
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no suitable interfaces for SSDP")
+	}
+	return targets, nil
+}
+
+// MulticastInterfaces returns every network interface that is up, supports
+// multicast, and is not a loopback interface.
+func MulticastInterfaces() ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var ifaces []net.Interface
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	return ifaces, nil
+}
+
+func ssdpConns(ctx context.Context, opts Options) ([]ssdpConn, error) {
+	if opts.Conn != nil {
+		groups := opts.Addrs
+		if len(groups) == 0 {
+			groups = []*net.UDPAddr{{IP: ssdpIPv4Group, Port: ssdpPort}}
+		}
+		return []ssdpConn{{opts.Conn, groups, unicastAddrs(opts, "udp4")}}, nil
+	}
+	targets, err := ssdpTargets(opts)
+	if err != nil {
+		return nil, err
+	}
+	lc := opts.ListenConfig
+	if lc == nil {
+		lc = new(net.ListenConfig)
+	}
+	conns := make([]ssdpConn, 0, len(targets))
+	for _, t := range targets {
+		conn, err := lc.ListenPacket(ctx, t.network, t.laddr)
+		if err != nil {
+			for _, c := range conns {
+				c.conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, ssdpConn{conn, t.groups, unicastAddrs(opts, t.network)})
+	}
+	return conns, nil
+}
+
+// unicastAddrs returns the Unicast addresses that can be reached via a socket
+// of the specified network.
+func unicastAddrs(opts Options, network string) []*net.UDPAddr {
+	if len(opts.Addrs) > 0 {
+		return nil
+	}
+	var addrs []*net.UDPAddr
+	for _, addr := range opts.Unicast {
+		if is4 := addr.IP.To4() != nil; network == "udp" || is4 == (network == "udp4") {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Search sends an M-SEARCH request and returns a channel that receives the
+// LOCATION of each responding device. Responses are deduplicated by USN. The
+// channel is closed once opts.Timeout has elapsed.
+func Search(opts Options) (<-chan string, error) {
+	responses, err := SearchResponses(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	locs := make(chan string)
+	go func() {
+		defer close(locs)
+		for r := range responses {
+			locs <- r.Location
+		}
+	}()
+	return locs, nil
+}
+
+// A Response is a device's response to a search.
+type Response struct {
+	ST       string
+	USN      string
+	Location string
+	Server   string
+	// MaxAge is how long the response remains valid, or zero if the device
+	// did not specify it.
+	MaxAge time.Duration
+	// BootID and ConfigID are the values of the BOOTID.UPNP.ORG and
+	// CONFIGID.UPNP.ORG headers, or -1 if the device did not specify them.
+	// See Notify for details.
+	BootID   int
+	ConfigID int
+	// Addr is the address that the response was received from.
+	Addr net.Addr
+	// RTT is the time elapsed between sending the first search and receiving
+	// the response.
+	RTT time.Duration
+}
+
+// SearchResponses is like Search, but the returned channel receives each
+// response in full. If ctx is done before opts.Timeout elapses, the search is
+// abandoned and the channel is closed immediately.
+func SearchResponses(ctx context.Context, opts Options) (<-chan Response, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	if opts.SearchTarget == "" && len(opts.SearchTargets) == 0 {
+		opts.SearchTarget = "upnp:rootdevice"
+	}
+	if opts.Sends == 0 {
+		opts.Sends = 3
+	}
+	if opts.SendInterval == 0 {
+		opts.SendInterval = 5 * time.Millisecond
+	}
+	if !opts.IPv4 && !opts.IPv6 {
+		opts.IPv4 = true
+	}
+	if opts.Logger == nil {
+		opts.Logger = logging.Nop
+	}
+	if opts.MX == 0 {
+		opts.MX = int(opts.Timeout / time.Second)
+	}
+	// MX must be between 1 and 5 seconds
+	if opts.MX < 1 {
+		opts.MX = 1
+	} else if opts.MX > 5 {
+		opts.MX = 5
+	}
+
+	conns, err := ssdpConns(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(opts.Timeout + 100*time.Millisecond)
+	for _, c := range conns {
+		c.conn.SetDeadline(deadline)
+		if opts.ReadBuffer > 0 {
+			if uc, ok := c.conn.(*net.UDPConn); ok {
+				uc.SetReadBuffer(opts.ReadBuffer)
+			}
+		}
+	}
+	start := time.Now()
+	// send the first round synchronously, discarding any sockets that
+	// couldn't send; only fail if all of them did
+	var ok []ssdpConn
+	var firstErr error
+	for _, c := range conns {
+		if err := sendSearch(c, opts); err != nil {
+			c.conn.Close()
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			ok = append(ok, c)
+		}
+	}
+	if len(ok) == 0 {
+		return nil, firstErr
+	}
+	// retransmit in the background, so that responses to the first round
+	// are delivered promptly
+	go func() {
+		for i := 1; i < opts.Sends; i++ {
+			select {
+			case <-time.After(opts.SendInterval):
+			case <-ctx.Done():
+				return
+			}
+			if time.Now().After(deadline) {
+				return
+			}
+			for _, c := range ok {
+				sendSearch(c, opts)
+			}
+		}
+	}()
+
+	// closing the sockets early causes their readers to exit
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			for _, c := range ok {
+				c.conn.Close()
+			}
+		case <-done:
+		}
+	}()
+
+	responses := make(chan Response)
+	go func() {
+		defer close(done)
+		mergeSSDP(ok, responses, start, opts.Logger, opts.Trace)
+	}()
+	return responses, nil
+}
+
+// sendSearch sends an M-SEARCH request for each target to each of the conn's
+// groups and unicast addresses.
+func sendSearch(c ssdpConn, opts Options) error {
+	targets := opts.SearchTargets
+	if opts.SearchTarget != "" {
+		targets = append([]string{opts.SearchTarget}, targets...)
+	}
+	// unicast first, since those responses arrive soonest; a failed unicast
+	// search doesn't invalidate the socket
+	sendSearchTo(c, c.unicast, targets, opts, 0)
+	return sendSearchTo(c, c.groups, targets, opts, opts.MX)
+}
+
+// sendSearchTo sends an M-SEARCH request for each target to each of addrs. If
+// mx is 0, the MX header is omitted, as for unicast searches.
+func sendSearchTo(c ssdpConn, addrs []*net.UDPAddr, targets []string, opts Options, mx int) error {
+	for _, group := range addrs {
+		for _, st := range targets {
+			var mxHeader string
+			if mx > 0 {
+				mxHeader = fmt.Sprintf("MX: %v\n", mx)
+			}
+			reqPacket := []byte(strings.Replace(fmt.Sprintf(`
+M-SEARCH * HTTP/1.1
+HOST: %v
+MAN: "ssdp:discover"
+%vST: %v
+
+`[1:], net.JoinHostPort(group.IP.String(), fmt.Sprint(group.Port)), mxHeader, st), "\n", "\r\n", -1))
+			if _, err := c.conn.WriteTo(reqPacket, group); err != nil {
+				opts.Logger.Debug("SSDP send failed", "laddr", c.conn.LocalAddr(), "raddr", group, "err", err)
+				return fmt.Errorf("couldn't write SSDP packet: %w", err)
+			}
+			if opts.Trace != nil {
+				opts.Trace(true, group, reqPacket)
+			}
+			opts.Logger.Debug("sent M-SEARCH", "laddr", c.conn.LocalAddr(), "raddr", group, "st", st, "mx", mx)
+		}
+	}
+	return nil
+}
+
+func mergeSSDP(conns []ssdpConn, merged chan<- Response, start time.Time, log Logger, trace func(bool, net.Addr, []byte)) {
+	defer close(merged)
+	responses := make(chan Response)
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(conn net.PacketConn) {
+			defer wg.Done()
+			doSSDP(conn, responses, start, log, trace)
+		}(c.conn)
+	}
+	go func() {
+		wg.Wait()
+		close(responses)
+	}()
+
+	seen := make(map[string]bool)
+	for r := range responses {
+		key := r.USN
+		if key == "" {
+			key = r.Location
+		}
+		if !seen[key] {
+			seen[key] = true
+			merged <- r
+		}
+	}
+}
+
+func doSSDP(conn net.PacketConn, responses chan<- Response, start time.Time, log Logger, trace func(bool, net.Addr, []byte)) {
+	defer conn.Close()
+
+	buf := packetPool.Get().(*[2048]byte)
+	defer packetPool.Put(buf)
+	for {
+		n, addr, err := conn.ReadFrom(buf[:])
+		if err != nil {
+			if err, ok := err.(net.Error); ok && err.Temporary() && !err.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		if trace != nil {
+			trace(false, addr, append([]byte(nil), buf[:n]...))
+		}
+		response, err := parseMessage(buf[:n])
+		if err != nil || response.method != "" {
+			log.Debug("ignoring malformed SSDP response", "raddr", addr, "err", err)
+			continue
+		} else if response.status != 200 {
+			log.Debug("ignoring SSDP error response", "raddr", addr, "status", response.status)
+			continue
+		}
+		if response.location == "" {
+			log.Debug("ignoring SSDP response without location", "raddr", addr)
+			continue
+		}
+		location, err := url.Parse(response.location)
+		if err != nil {
+			log.Debug("ignoring SSDP response with invalid location", "raddr", addr, "err", err)
+			continue
+		}
+		// IPv6 devices typically advertise a link-local address, which is
+		// useless without the zone of the interface it was received on
+		if ua, ok := addr.(*net.UDPAddr); ok && ua.Zone != "" {
+			if ip := net.ParseIP(location.Hostname()); ip != nil && ip.IsLinkLocalUnicast() && ip.To4() == nil {
+				host := net.JoinHostPort(ip.String()+"%"+ua.Zone, location.Port())
+				if location.Port() == "" {
+					host = "[" + ip.String() + "%" + ua.Zone + "]"
+				}
+				location.Host = host
+			}
+		}
+		log.Debug("received SSDP response", "raddr", addr, "location", location.String(), "usn", response.usn, "server", response.server)
+		responses <- Response{
+			ST:       response.st,
+			USN:      response.usn,
+			Location: location.String(),
+			Server:   response.server,
+			MaxAge:   parseMaxAge(response.cacheControl),
+			BootID:   parseID(response.bootID),
+			ConfigID: parseID(response.configID),
+			Addr:     addr,
+			RTT:      time.Since(start),
+		}
+	}
+}
+
+// A Notify is an announcement multicast by a device, either advertising its
+// presence (NTS "ssdp:alive") or its departure (NTS "ssdp:byebye").
+type Notify struct {
+	NT       string
+	NTS      string
+	USN      string
+	Location string
+	// MaxAge is how long the announcement remains valid, or zero if the
+	// device did not specify it.
+	MaxAge time.Duration
+	// BootID is the value of the BOOTID.UPNP.ORG header, which UPnP 1.1
+	// devices increment each time they reboot (or otherwise lose state), or -1
+	// if the device did not specify it. For NTS "ssdp:update", NextBootID is
+	// the device's new BootID; otherwise, it is -1.
+	BootID     int
+	NextBootID int
+	// ConfigID is the value of the CONFIGID.UPNP.ORG header, which changes
+	// whenever the device's description changes, or -1 if the device did not
+	// specify it.
+	ConfigID int
+}
+
+// parseID parses a BOOTID.UPNP.ORG or similar header, returning -1 if it is
+// missing or invalid.
+func parseID(h string) int {
+	id, err := strconv.Atoi(strings.TrimSpace(h))
+	if err != nil || id < 0 {
+		return -1
+	}
+	return id
+}
+
+func parseMaxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		kv := strings.SplitN(directive, "=", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "max-age") {
+			if secs, err := strconv.Atoi(strings.TrimSpace(kv[1])); err == nil && secs > 0 {
+				return time.Duration(secs) * time.Second
+			}
+		}
+	}
+	return 0
+}
+
+// ListenNotify listens for NOTIFY announcements on the IPv4 SSDP multicast
+// group. The returned channel is closed when ctx is canceled.
+func ListenNotify(ctx context.Context) (<-chan Notify, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, &net.UDPAddr{IP: ssdpIPv4Group, Port: ssdpPort})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	ch := make(chan Notify)
+	go func() {
+		defer close(ch)
+		buf := packetPool.Get().(*[2048]byte)
+		defer packetPool.Put(buf)
+		for {
+			n, _, err := conn.ReadFrom(buf[:])
+			if err != nil {
+				if err, ok := err.(net.Error); ok && err.Temporary() && !err.Timeout() {
+					time.Sleep(5 * time.Millisecond)
+					continue
+				}
+				return
+			}
+			m, err := parseMessage(buf[:n])
+			if err != nil || m.method != "NOTIFY" {
+				continue
+			}
+			notify := Notify{
+				NT:         m.nt,
+				NTS:        m.nts,
+				USN:        m.usn,
+				Location:   m.location,
+				MaxAge:     parseMaxAge(m.cacheControl),
+				BootID:     parseID(m.bootID),
+				NextBootID: parseID(m.nextBootID),
+				ConfigID:   parseID(m.configID),
+			}
+			select {
+			case ch <- notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
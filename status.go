@@ -0,0 +1,148 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"lukechampine.com/upnp/internal/clock"
+	"lukechampine.com/upnp/internal/goupnp"
+)
+
+// Status describes the state of a router's WAN connection.
+type Status struct {
+	// ConnectionStatus is typically one of "Unconfigured", "Connecting",
+	// "Connected", "PendingDisconnect", "Disconnecting", or "Disconnected".
+	ConnectionStatus string
+	// LastConnectionError describes why the most recent connection attempt
+	// failed, e.g. "ERROR_NO_CARRIER", or "ERROR_NONE" if it succeeded.
+	LastConnectionError string
+	// Uptime is how long the WAN connection has been up.
+	Uptime time.Duration
+}
+
+// Connected reports whether the router's WAN connection is up.
+func (s Status) Connected() bool {
+	return s.ConnectionStatus == "Connected"
+}
+
+// RequestConnection asks the router to establish its WAN connection, if it is
+// not already connected. The router typically returns before the connection
+// is up; poll Status to determine when it is.
+func (d Device) RequestConnection(ctx context.Context) error {
+	return d.client.RequestConnection(ctx)
+}
+
+// ForceTermination asks the router to tear down its WAN connection
+// immediately. Following it with RequestConnection causes routers with
+// dynamically-assigned addresses (e.g. PPPoE on DSL lines) to reconnect,
+// usually with a new external IP. Note that the host is offline in the
+// meantime, and some routers clear their mappings when disconnected.
+func (d Device) ForceTermination(ctx context.Context) error {
+	return d.client.ForceTermination(ctx)
+}
+
+// ConnectionTypeInfo describes how a router's WAN connection is configured.
+type ConnectionTypeInfo struct {
+	// Type is typically "IP_Routed" or "IP_Bridged" (or, for PPP
+	// connections, e.g. "PPPoE_Bridged"), or "Unconfigured".
+	Type string
+	// Possible lists the types that the connection can be set to.
+	Possible []string
+}
+
+// Bridged reports whether the connection is bridged, in which case the router
+// does not route traffic (and port mappings have no effect).
+func (ci ConnectionTypeInfo) Bridged() bool {
+	return strings.HasSuffix(ci.Type, "_Bridged")
+}
+
+// ConnectionType returns the connection type of the router's WAN connection.
+func (d Device) ConnectionType(ctx context.Context) (ConnectionTypeInfo, error) {
+	resp, err := d.client.GetConnectionTypeInfo(ctx)
+	if err != nil {
+		return ConnectionTypeInfo{}, err
+	}
+	ci := ConnectionTypeInfo{Type: strings.TrimSpace(resp.NewConnectionType)}
+	for _, t := range strings.Split(resp.NewPossibleConnectionTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			ci.Possible = append(ci.Possible, t)
+		}
+	}
+	return ci, nil
+}
+
+// SetConnectionType sets the connection type of the router's WAN connection,
+// which must be one of the types reported by ConnectionType. Most routers do
+// not permit this.
+func (d Device) SetConnectionType(ctx context.Context, t string) error {
+	return d.client.SetConnectionType(ctx, goupnp.SetConnectionTypeRequest{NewConnectionType: t})
+}
+
+// NATEnabled reports whether the router performs NAT. Routers in bridge mode
+// (e.g. a modem passing the public address through to another router) do not,
+// in which case port mappings have no effect and need not be created.
+func (d Device) NATEnabled(ctx context.Context) (bool, error) {
+	resp, err := d.client.GetNATRSIPStatus(ctx)
+	return resp.NewNATEnabled, err
+}
+
+// Status returns the status of the router's WAN connection.
+func (d Device) Status(ctx context.Context) (Status, error) {
+	resp, err := d.client.GetStatusInfo(ctx)
+	return Status{
+		ConnectionStatus:    resp.NewConnectionStatus,
+		LastConnectionError: resp.NewLastConnectionError,
+		Uptime:              time.Duration(resp.NewUptime) * time.Second,
+	}, err
+}
+
+// WaitForConnection polls the router until it reports that its WAN connection
+// is up and that it has a valid external IP, which it returns. This is useful
+// after the router boots, or after a reconnection (e.g. a PPPoE renegotiation),
+// before publishing the external address to peers. Routers that don't
+// implement GetStatusInfo are judged by their external IP alone. Errors
+// communicating with the router are assumed to be temporary, since a booting
+// router may not respond at all; if ctx is done first, the most recent
+// reason for waiting is included in the returned error.
+func (d Device) WaitForConnection(ctx context.Context) (netip.Addr, error) {
+	const minInterval, maxInterval = 500 * time.Millisecond, 5 * time.Second
+	interval := minInterval
+	clk := d.client.Clock()
+	for {
+		addr, err := d.connectedAddr(ctx)
+		if err == nil {
+			return addr, nil
+		}
+		if !clock.Sleep(clk, interval, ctx.Done()) {
+			return netip.Addr{}, fmt.Errorf("%w (last status: %v)", ctx.Err(), err)
+		}
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// connectedAddr returns the router's external IP if its WAN connection is up,
+// and otherwise an error describing why it isn't.
+func (d Device) connectedAddr(ctx context.Context) (netip.Addr, error) {
+	s, err := d.Status(ctx)
+	if err == nil && !s.Connected() {
+		if s.LastConnectionError != "" && s.LastConnectionError != "ERROR_NONE" {
+			return netip.Addr{}, fmt.Errorf("connection status is %v (%v)", s.ConnectionStatus, s.LastConnectionError)
+		}
+		return netip.Addr{}, fmt.Errorf("connection status is %v", s.ConnectionStatus)
+	} else if err != nil && !errors.Is(err, ErrInvalidAction) {
+		return netip.Addr{}, err
+	}
+	addr, err := d.ExternalAddr(ctx)
+	if err != nil {
+		return netip.Addr{}, err
+	} else if addr.IsUnspecified() {
+		return netip.Addr{}, errors.New("router has no external IP")
+	}
+	return addr, nil
+}
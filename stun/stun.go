@@ -0,0 +1,201 @@
+// Package stun implements a minimal STUN (RFC 5389) client, supporting only
+// Binding requests.
+//
+// It is intended for discovering the public address of this host as seen by
+// a server on the internet, e.g. to cross-check the external IP reported by a
+// router.
+package stun
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// DefaultServer is a public STUN server, used when no server is specified.
+const DefaultServer = "stun.l.google.com:19302"
+
+const (
+	magicCookie = 0x2112A442
+
+	typeBindingRequest   = 0x0001
+	typeBindingSuccess   = 0x0101
+	typeBindingError     = 0x0111
+	attrMappedAddress    = 0x0001
+	attrXORMappedAddress = 0x0020
+	attrErrorCode        = 0x0009
+
+	headerLen = 20
+)
+
+// An ErrorResponse is a Binding error response returned by a STUN server.
+type ErrorResponse struct {
+	Code   int
+	Reason string
+}
+
+// Error implements error.
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("STUN error: %v (error code %d)", e.Reason, e.Code)
+}
+
+// bindingRequest returns a Binding request with the specified transaction ID.
+func bindingRequest(txid [12]byte) []byte {
+	req := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(req[0:], typeBindingRequest)
+	binary.BigEndian.PutUint16(req[2:], 0) // no attributes
+	binary.BigEndian.PutUint32(req[4:], magicCookie)
+	copy(req[8:], txid[:])
+	return req
+}
+
+// parseResponse parses a Binding response to the request with the specified
+// transaction ID. It returns errWrongTransaction for responses to other
+// requests.
+func parseResponse(resp []byte, txid [12]byte) (netip.AddrPort, error) {
+	if len(resp) < headerLen || binary.BigEndian.Uint32(resp[4:]) != magicCookie {
+		return netip.AddrPort{}, errors.New("invalid STUN response")
+	} else if !bytes.Equal(resp[8:20], txid[:]) {
+		return netip.AddrPort{}, errWrongTransaction
+	}
+	typ := binary.BigEndian.Uint16(resp[0:])
+	length := int(binary.BigEndian.Uint16(resp[2:]))
+	if headerLen+length > len(resp) {
+		return netip.AddrPort{}, errors.New("truncated STUN response")
+	}
+	attrs := resp[headerLen : headerLen+length]
+
+	var mapped, xorMapped netip.AddrPort
+	for len(attrs) >= 4 {
+		at := binary.BigEndian.Uint16(attrs[0:])
+		al := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+al > len(attrs) {
+			return netip.AddrPort{}, errors.New("truncated STUN attribute")
+		}
+		val := attrs[4 : 4+al]
+		switch at {
+		case attrMappedAddress:
+			mapped, _ = parseAddress(val, nil)
+		case attrXORMappedAddress:
+			// the port and IP are XORed with the magic cookie, and an IPv6
+			// address additionally with the transaction ID
+			key := append(binary.BigEndian.AppendUint32(nil, magicCookie), txid[:]...)
+			xorMapped, _ = parseAddress(val, key)
+		case attrErrorCode:
+			if typ == typeBindingError && len(val) >= 4 {
+				return netip.AddrPort{}, &ErrorResponse{
+					Code:   int(val[2]&7)*100 + int(val[3]),
+					Reason: string(val[4:]),
+				}
+			}
+		}
+		// attributes are padded to a multiple of 4 bytes
+		attrs = attrs[min(len(attrs), 4+(al+3)&^3):]
+	}
+	switch {
+	case typ == typeBindingError:
+		return netip.AddrPort{}, &ErrorResponse{Reason: "unknown error"}
+	case typ != typeBindingSuccess:
+		return netip.AddrPort{}, fmt.Errorf("unexpected STUN message type %#04x", typ)
+	case xorMapped.IsValid():
+		return xorMapped, nil
+	case mapped.IsValid():
+		return mapped, nil
+	default:
+		return netip.AddrPort{}, errors.New("STUN response did not include an address")
+	}
+}
+
+var errWrongTransaction = errors.New("response to another transaction")
+
+// parseAddress parses a (XOR-)MAPPED-ADDRESS attribute, XORing the port and
+// address with key, if non-nil.
+func parseAddress(val, key []byte) (netip.AddrPort, error) {
+	if len(val) < 4 {
+		return netip.AddrPort{}, errors.New("invalid address attribute")
+	}
+	family := val[1]
+	port := binary.BigEndian.Uint16(val[2:])
+	ip := append([]byte(nil), val[4:]...)
+	if key != nil {
+		port ^= binary.BigEndian.Uint16(key)
+		for i := range ip {
+			if i < len(key) {
+				ip[i] ^= key[i]
+			}
+		}
+	}
+	switch {
+	case family == 1 && len(ip) == 4:
+		return netip.AddrPortFrom(netip.AddrFrom4([4]byte(ip)), port), nil
+	case family == 2 && len(ip) == 16:
+		return netip.AddrPortFrom(netip.AddrFrom16([16]byte(ip)), port), nil
+	default:
+		return netip.AddrPort{}, errors.New("invalid address attribute")
+	}
+}
+
+// ExternalAddr sends a Binding request to the STUN server at addr (host:port)
+// via IPv4, returning this host's address as observed by the server. If addr
+// is empty, DefaultServer is used. Requests are retransmitted as described in
+// RFC 5389 section 7.2.1 until ctx is done.
+func ExternalAddr(ctx context.Context, addr string) (netip.AddrPort, error) {
+	if addr == "" {
+		addr = DefaultServer
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp4", addr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var txid [12]byte
+	if _, err := rand.Read(txid[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	req := bindingRequest(txid)
+	const maxAttempts = 7
+	timeout := 500 * time.Millisecond
+	resp := make([]byte, 1500)
+	for i := 0; i < maxAttempts; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return netip.AddrPort{}, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(resp)
+			if ctx.Err() != nil {
+				return netip.AddrPort{}, ctx.Err()
+			} else if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return netip.AddrPort{}, err
+			}
+			ap, err := parseResponse(resp[:n], txid)
+			if err == errWrongTransaction {
+				continue
+			}
+			return ap, err
+		}
+		timeout *= 2
+	}
+	return netip.AddrPort{}, errors.New("STUN server did not respond")
+}
@@ -0,0 +1,205 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MappingTable is a live copy of a router's port mapping table, suitable for
+// driving a UI. It subscribes to the router's events and refetches the table
+// whenever the router reports a change to PortMappingNumberOfEntries.
+//
+// Events only signal that mappings were added or removed; changes that leave
+// the number of entries unchanged (e.g. a mapping being replaced) are only
+// noticed by polling, if enabled. Likewise, some routers never send events at
+// all.
+type MappingTable struct {
+	d        Device
+	sub      *Subscription
+	interval time.Duration
+	changes  chan []PortMapping
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	refreshMu sync.Mutex // serializes refreshes
+	mu        sync.Mutex
+	mappings  []PortMapping
+	err       error
+	closed    bool
+}
+
+// WatchMappings fetches the router's mapping table and keeps a copy of it up
+// to date until Close is called. If interval is positive, the table is also
+// refetched every interval, catching changes that events miss. If the router
+// does not support event subscriptions, WatchMappings returns an error unless
+// interval is positive, in which case the table is only polled.
+func (d Device) WatchMappings(ctx context.Context, interval time.Duration) (*MappingTable, error) {
+	ms, err := d.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := d.Subscribe(ctx)
+	if err != nil && interval <= 0 {
+		return nil, err
+	}
+	t := &MappingTable{
+		d:        d,
+		sub:      sub,
+		interval: interval,
+		changes:  make(chan []PortMapping, 1),
+		done:     make(chan struct{}),
+		mappings: ms,
+		err:      err,
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	go t.run()
+	return t, nil
+}
+
+// Mappings returns the most recently fetched copy of the table.
+func (t *MappingTable) Mappings() []PortMapping {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]PortMapping(nil), t.mappings...)
+}
+
+// Changes returns a channel that receives a copy of the table whenever it
+// changes. Differences in the remaining lease of existing mappings are not
+// considered changes. If the receiver falls behind, intermediate copies are
+// discarded, so the channel always holds the latest table. The channel is
+// closed when the MappingTable is closed.
+func (t *MappingTable) Changes() <-chan []PortMapping {
+	return t.changes
+}
+
+// Err returns the error encountered by the most recent refresh, or nil if it
+// succeeded. If the table is being polled because the router does not support
+// event subscriptions, Err initially returns the subscription error.
+func (t *MappingTable) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Refresh refetches the table immediately, e.g. after the caller has modified
+// it.
+func (t *MappingTable) Refresh(ctx context.Context) error {
+	t.refreshMu.Lock()
+	defer t.refreshMu.Unlock()
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return errors.New("mapping table is closed")
+	}
+	ms, err := t.d.Mappings(ctx)
+	t.mu.Lock()
+	t.err = err
+	changed := err == nil && !sameMappings(t.mappings, ms)
+	if err == nil {
+		t.mappings = ms
+	}
+	t.mu.Unlock()
+	if changed {
+		// discard any unreceived copy in favor of the latest
+		select {
+		case <-t.changes:
+		default:
+		}
+		t.changes <- append([]PortMapping(nil), ms...)
+	}
+	return err
+}
+
+func (t *MappingTable) run() {
+	defer close(t.done)
+	var events <-chan Event
+	if t.sub != nil {
+		events = t.sub.Events()
+	}
+	var timer Timer
+	var tick <-chan time.Time
+	if t.interval > 0 {
+		timer = t.d.client.Clock().NewTimer(t.interval)
+		defer timer.Stop()
+		tick = timer.C()
+	}
+	for {
+		select {
+		case e := <-events:
+			t.handleEvent(e)
+		case <-tick:
+			t.refresh()
+			timer.Reset(t.interval)
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// handleEvent refreshes the table if e reports a different number of entries
+// than the current copy holds.
+func (t *MappingTable) handleEvent(e Event) {
+	v, ok := e.Variables["PortMappingNumberOfEntries"]
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	n := len(t.mappings)
+	t.mu.Unlock()
+	if count, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && count == n {
+		return
+	}
+	t.refresh()
+}
+
+func (t *MappingTable) refresh() {
+	ctx, cancel := context.WithTimeout(t.ctx, 30*time.Second)
+	defer cancel()
+	t.Refresh(ctx)
+}
+
+// Close stops watching the table, cancelling the event subscription, and
+// closes the Changes channel.
+func (t *MappingTable) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return errors.New("mapping table already closed")
+	}
+	t.closed = true
+	t.mu.Unlock()
+	t.cancel()
+	<-t.done
+	var err error
+	if t.sub != nil {
+		err = t.sub.Close()
+	}
+	// wait for any concurrent Refresh before closing the channel
+	t.refreshMu.Lock()
+	close(t.changes)
+	t.refreshMu.Unlock()
+	return err
+}
+
+// sameMappings reports whether a and b contain the same mappings, ignoring
+// differences in remaining lease.
+func sameMappings(a, b []PortMapping) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		x.Lease, y.Lease = 0, 0
+		if x != y || (a[i].Lease == 0) != (b[i].Lease == 0) {
+			return false
+		}
+	}
+	return true
+}
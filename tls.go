@@ -0,0 +1,135 @@
+package upnp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"lukechampine.com/upnp/internal/goupnp"
+)
+
+// CertificatePins records the certificate presented by each device served over
+// HTTPS, trusting it on first use and rejecting any other certificate
+// thereafter. This suits devices with self-signed certificates, which cannot
+// be verified conventionally. To remain effective across restarts, the pins
+// should be persisted via MarshalJSON and UnmarshalJSON. The zero value is
+// ready for use.
+type CertificatePins struct {
+	mu   sync.Mutex
+	pins map[string]string // host:port -> hex-encoded SHA-256 of certificate
+}
+
+func (p *CertificatePins) check(addr string, cert []byte) error {
+	sum := sha256.Sum256(cert)
+	fp := hex.EncodeToString(sum[:])
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pins == nil {
+		p.pins = make(map[string]string)
+	}
+	if pinned, ok := p.pins[addr]; !ok {
+		p.pins[addr] = fp
+	} else if pinned != fp {
+		return fmt.Errorf("certificate presented by %v (SHA-256 %v) does not match pinned certificate (SHA-256 %v)", addr, fp, pinned)
+	}
+	return nil
+}
+
+// Forget removes the pin for the specified host:port, e.g. after a device's
+// certificate has legitimately changed.
+func (p *CertificatePins) Forget(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pins, addr)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p *CertificatePins) MarshalJSON() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pins == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(p.pins)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *CertificatePins) UnmarshalJSON(b []byte) error {
+	var pins map[string]string
+	if err := json.Unmarshal(b, &pins); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pins = pins
+	return nil
+}
+
+// deviceTLSClient returns a copy of client that, when connecting to devices
+// with private or link-local addresses over HTTPS, presents cert (if non-nil)
+// and, if skipVerify is set, checks their certificates against pins instead
+// of verifying them conventionally, or skips verification entirely if pins is
+// nil. Certificates presented by other hosts are verified normally. If
+// client's transport is not an *http.Transport, client is returned unmodified.
+func deviceTLSClient(client *http.Client, pins *CertificatePins, skipVerify bool, cert *tls.Certificate) *http.Client {
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return client
+	}
+	t = t.Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	base := t.TLSClientConfig
+	if base == nil {
+		base = new(tls.Config)
+	}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := base.Clone()
+		if goupnp.IsLocalHost(host) && cert != nil {
+			cfg.Certificates = []tls.Certificate{*cert}
+		}
+		if goupnp.IsLocalHost(host) && skipVerify {
+			cfg.InsecureSkipVerify = true
+			if pins != nil {
+				cfg.VerifyPeerCertificate = func(certs [][]byte, _ [][]*x509.Certificate) error {
+					if len(certs) == 0 {
+						return fmt.Errorf("%v presented no certificate", addr)
+					}
+					return pins.check(addr, certs[0])
+				}
+			}
+		} else if cfg.ServerName == "" {
+			cfg.ServerName = host
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+	cc := *client
+	cc.Transport = t
+	return &cc
+}
@@ -6,68 +6,376 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net"
+	"net/netip"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"lukechampine.com/upnp/igd"
 	"lukechampine.com/upnp/internal/goupnp"
+	"lukechampine.com/upnp/internal/logging"
+	"lukechampine.com/upnp/ssdp"
 )
 
 // A Device can forward ports and discover its external IP.
 type Device struct {
 	internalIP string
+	pinnedIP   bool // internalIP was set via WithInternalIP
 	client     goupnp.IGDClient
+	// from the SSDP response, or -1 if unknown
+	bootID   int
+	configID int
 }
 
-// Forward forwards the specified port for the specified protocol, which must be
-// "TCP" or "UDP".
-func (d Device) Forward(port uint16, proto string, desc string) error {
-	return d.client.AddPortMapping(goupnp.AddPortMappingRequest{
+// A Logger receives debug messages, accompanied by alternating keys and
+// values in the style of log/slog. A *slog.Logger satisfies this interface.
+type Logger = logging.Logger
+
+// Metrics receives notifications of UPnP operations, allowing applications to
+// track their latency, failure rates, and so on with the monitoring system of
+// their choice. Methods may be called concurrently.
+type Metrics interface {
+	// ActionStarted is called before a Device invokes a UPnP action, e.g.
+	// "AddPortMapping".
+	ActionStarted(action string)
+	// ActionCompleted is called after the action completes, including any
+	// retries.
+	ActionCompleted(action string, elapsed time.Duration, err error)
+	// DiscoveryCompleted is called when DiscoverAll (or Discover) finishes
+	// searching, with the number of Devices found.
+	DiscoveryCompleted(count int)
+}
+
+// A Forwarder forwards ports on a UPnP router. It is implemented by Device;
+// code that accepts a Forwarder instead of a Device can substitute a fake in
+// tests.
+type Forwarder interface {
+	Forward(ctx context.Context, port uint16, proto string, desc string, opts ...ForwardOption) error
+	Clear(ctx context.Context, port uint16, proto string) error
+	IsForwarded(ctx context.Context, port uint16, proto string) bool
+	ExternalIP(ctx context.Context) (string, error)
+}
+
+var _ Forwarder = Device{}
+
+type forwardOptions struct {
+	lease          time.Duration
+	internalPort   uint16
+	internalClient string
+	owner          *Owner
+	policy         ForwardPolicy
+}
+
+// A ForwardOption modifies the behavior of Forward.
+type ForwardOption func(*forwardOptions)
+
+// WithLease requests a mapping that expires after the specified duration,
+// which is rounded up to the nearest second. A lease of 0 requests a permanent
+// mapping, which is the default. If the router only supports permanent
+// mappings, the lease is ignored unless the Device was created with
+// WithStrictLeases.
+func WithLease(lease time.Duration) ForwardOption {
+	return func(o *forwardOptions) { o.lease = lease }
+}
+
+// WithInternalPort forwards the external port to a different port on this
+// host. By default, the internal port is the same as the external port.
+func WithInternalPort(port uint16) ForwardOption {
+	return func(o *forwardOptions) { o.internalPort = port }
+}
+
+// WithInternalClient forwards the port to the specified LAN host instead of
+// this one. This allows mappings to be managed on behalf of other devices, such
+// as game consoles or cameras. Note that many routers only permit hosts to
+// create mappings for themselves.
+func WithInternalClient(ip string) ForwardOption {
+	return func(o *forwardOptions) { o.internalClient = ip }
+}
+
+// WithInternalClientAddr is like WithInternalClient, but accepts a netip.Addr.
+func WithInternalClientAddr(addr netip.Addr) ForwardOption {
+	return func(o *forwardOptions) { o.internalClient = addr.Unmap().String() }
+}
+
+// leaseSeconds converts a lease duration to the whole number of seconds
+// expected by the router.
+func leaseSeconds(lease time.Duration) uint32 {
+	if lease <= 0 {
+		return 0
+	}
+	secs := (lease + time.Second - 1) / time.Second
+	if secs > math.MaxUint32 {
+		secs = math.MaxUint32
+	}
+	return uint32(secs)
+}
+
+func (d Device) mappingRequest(port uint16, proto string, desc string, opts []ForwardOption) (goupnp.AddPortMappingRequest, error) {
+	proto, err := ParseProto(proto)
+	if err != nil {
+		return goupnp.AddPortMappingRequest{}, err
+	}
+	o := forwardOptions{
+		internalPort:   port,
+		internalClient: d.internalIP,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// routers accept anything, but only IPv4 addresses are meaningful
+	if addr, err := netip.ParseAddr(o.internalClient); err != nil || !addr.Unmap().Is4() {
+		return goupnp.AddPortMappingRequest{}, fmt.Errorf("invalid internal client %q: must be an IPv4 address", o.internalClient)
+	}
+	if o.owner != nil {
+		if err := o.owner.validate(); err != nil {
+			return goupnp.AddPortMappingRequest{}, err
+		}
+		desc = strings.TrimSpace(desc + " " + o.owner.tag())
+	}
+	return goupnp.AddPortMappingRequest{
 		NewExternalPort:           port,
 		NewProtocol:               proto,
-		NewInternalPort:           port,
-		NewInternalClient:         d.internalIP,
+		NewInternalPort:           o.internalPort,
+		NewInternalClient:         o.internalClient,
 		NewEnabled:                true,
 		NewPortMappingDescription: desc,
-		NewLeaseDuration:          0,
-	})
+		NewLeaseDuration:          leaseSeconds(o.lease),
+	}, nil
+}
+
+// requestedMapping returns the mapping described by req.
+func requestedMapping(req goupnp.AddPortMappingRequest) PortMapping {
+	return PortMapping{
+		ExternalPort:   req.NewExternalPort,
+		InternalPort:   req.NewInternalPort,
+		InternalClient: req.NewInternalClient,
+		Protocol:       req.NewProtocol,
+		Description:    req.NewPortMappingDescription,
+		Enabled:        req.NewEnabled,
+		Lease:          time.Duration(req.NewLeaseDuration) * time.Second,
+	}
+}
+
+// Forward forwards the specified port for the specified protocol, which must be
+// TCP or UDP (in any case).
+//
+// If the port is already mapped to another client, the returned error is a
+// *ConflictError describing the existing mapping. WithPolicy controls how
+// existing mappings are treated.
+func (d Device) Forward(ctx context.Context, port uint16, proto string, desc string, opts ...ForwardOption) error {
+	req, err := d.mappingRequest(port, proto, desc, opts)
+	if err != nil {
+		return err
+	}
+	if p := forwardPolicy(opts); p != ForwardDefault {
+		err = d.forwardWithPolicy(ctx, req, p)
+	} else {
+		err = d.client.AddPortMapping(ctx, req)
+	}
+	var ce *ConflictError
+	if errors.Is(err, ErrConflictInMappingEntry) && !errors.As(err, &ce) {
+		err = d.conflictError(ctx, port, req.NewProtocol, err)
+	}
+	return err
+}
+
+// ForwardBoth forwards the specified port for both TCP and UDP. If the UDP
+// mapping fails, the TCP mapping is cleared, so that either both protocols are
+// forwarded or neither is. A TCP mapping that existed before the call is left
+// in place.
+func (d Device) ForwardBoth(ctx context.Context, port uint16, desc string, opts ...ForwardOption) error {
+	// if we can't tell whether the TCP mapping already exists, assume it
+	// does, so that we never clear a mapping we didn't create
+	s, err := d.MappingStatus(ctx, port, TCP)
+	existed := err != nil || s.Exists
+	if err := d.Forward(ctx, port, TCP, desc, opts...); err != nil {
+		return err
+	}
+	if err := d.Forward(ctx, port, UDP, desc, opts...); err != nil {
+		if !existed {
+			d.Clear(ctx, port, TCP)
+		}
+		return err
+	}
+	return nil
+}
+
+// ForwardAny is like Forward, but if the specified external port is already
+// mapped, an alternative external port is chosen instead. The external port
+// actually mapped is returned. Unless overridden with WithInternalPort, the
+// internal port is always the specified port.
+//
+// On routers that support it, the alternative port is chosen by the router;
+// otherwise, successive ports are tried until one succeeds.
+func (d Device) ForwardAny(ctx context.Context, port uint16, proto string, desc string, opts ...ForwardOption) (uint16, error) {
+	req, err := d.mappingRequest(port, proto, desc, opts)
+	if err != nil {
+		return 0, err
+	}
+	if igd.ServiceVersion(d.client.ServiceType()) >= 2 {
+		resp, err := d.client.AddAnyPortMapping(ctx, req)
+		if err == nil {
+			return resp.NewReservedPort, nil
+		} else if !isFault(err) {
+			return 0, err
+		}
+		// some IGDv2 routers don't actually implement AddAnyPortMapping; fall
+		// back to the v1 method
+	}
+
+	const maxAttempts = 16
+	for i := 0; i < maxAttempts && req.NewExternalPort != 0; i++ {
+		err := d.client.AddPortMapping(ctx, req)
+		if err == nil {
+			return req.NewExternalPort, nil
+		} else if !errors.Is(err, ErrConflictInMappingEntry) {
+			return 0, err
+		}
+		req.NewExternalPort++
+	}
+	return 0, fmt.Errorf("could not find an available external port near %v", port)
 }
 
 // IsForwarded returns true if the specified port is forwarded to this host.
-func (d Device) IsForwarded(port uint16, proto string) bool {
-	resp, _ := d.client.GetSpecificPortMappingEntry(goupnp.GetSpecificPortMappingEntryRequest{
-		NewExternalPort: port,
-		NewProtocol:     proto,
-	})
-	return resp.NewEnabled && resp.NewInternalClient == d.internalIP
+// Errors are treated as the port not being forwarded; use MappingStatus to
+// distinguish them.
+func (d Device) IsForwarded(ctx context.Context, port uint16, proto string) bool {
+	s, _ := d.MappingStatus(ctx, port, proto)
+	return s.Enabled && s.Local
 }
 
 // Clear un-forwards a port. No error is returned if the port is not forwarded.
-func (d Device) Clear(port uint16, proto string) error {
-	err := d.client.DeletePortMapping(goupnp.DeletePortMappingRequest{
+func (d Device) Clear(ctx context.Context, port uint16, proto string) error {
+	proto, err := ParseProto(proto)
+	if err != nil {
+		return err
+	}
+	err = d.client.DeletePortMapping(ctx, goupnp.DeletePortMappingRequest{
 		NewExternalPort: port,
 		NewProtocol:     proto,
 	})
-	if err != nil && strings.Contains(err.Error(), "NoSuchEntryInArray") {
+	if errors.Is(err, ErrNoSuchEntryInArray) {
 		err = nil
 	}
 	return err
 }
 
+// ClearBoth un-forwards the specified port for both TCP and UDP. Both
+// protocols are cleared even if clearing one of them fails.
+func (d Device) ClearBoth(ctx context.Context, port uint16) error {
+	tcpErr := d.Clear(ctx, port, TCP)
+	udpErr := d.Clear(ctx, port, UDP)
+	if tcpErr != nil {
+		return tcpErr
+	}
+	return udpErr
+}
+
 // ExternalIP returns the router's external IP.
-func (d Device) ExternalIP() (string, error) {
-	resp, err := d.client.GetExternalIPAddress()
+func (d Device) ExternalIP(ctx context.Context) (string, error) {
+	resp, err := d.client.GetExternalIPAddress(ctx)
 	return resp.NewExternalIPAddress, err
 }
 
-// Location returns the URL of the device.
+// InternalIP returns the address of this host that mappings point at by
+// default, i.e. the address on the same network as the router, unless
+// overridden via WithInternalIP.
+func (d Device) InternalIP() string {
+	return d.internalIP
+}
+
+// ExternalAddr is like ExternalIP, but returns a netip.Addr. An error is
+// returned if the router reports an invalid address.
+func (d Device) ExternalAddr(ctx context.Context) (netip.Addr, error) {
+	ip, err := d.ExternalIP(ctx)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("router reported invalid external IP %q", ip)
+	}
+	return addr, nil
+}
+
+// WithRefreshedInternalIP returns a copy of the Device whose internal IP has
+// been re-detected on the router's network, and reports whether it changed.
+// The internal IP may change when a DHCP lease is renewed, or when a laptop
+// roams between networks; mappings created via the returned Device point at
+// the new address, but existing mappings must be re-created by the caller. (A
+// Reconciler can do this automatically; see Reconciler.WatchInternalIP.) An
+// internal IP set via WithInternalIP is never replaced.
+func (d Device) WithRefreshedInternalIP() (Device, bool, error) {
+	if d.pinnedIP {
+		return d, false, nil
+	}
+	ip, err := getInternalIP(d.Location())
+	if err != nil {
+		return d, false, err
+	}
+	changed := ip != d.internalIP
+	d.internalIP = ip
+	return d, changed, nil
+}
+
+// InternalAddr is like InternalIP, but returns a netip.Addr.
+func (d Device) InternalAddr() netip.Addr {
+	addr, _ := netip.ParseAddr(d.internalIP)
+	return addr
+}
+
+// Location returns the URL of the device description, suitable for passing to
+// Connect.
 func (d Device) Location() string {
 	return d.client.Location()
 }
 
+// serviceClient returns a client for another service on the same router as d.
+func (d Device) serviceClient(ctx context.Context, serviceType string) (goupnp.IGDClient, error) {
+	clients, err := d.client.Siblings(ctx, serviceType)
+	if err != nil {
+		return goupnp.IGDClient{}, err
+	} else if len(clients) == 0 {
+		return goupnp.IGDClient{}, fmt.Errorf("no %v service found at %v", serviceType, d.client.Location())
+	}
+	return clients[0], nil
+}
+
+// Call invokes an arbitrary UPnP action on the specified service of the
+// Device, which may be any service on the same root device; if serviceType is
+// empty, the Device's WAN connection service is used. req is marshaled as the
+// action's input arguments (nil for none), with each exported field becoming an argument of
+// the same name, and the action's output arguments are unmarshaled into resp,
+// which may be nil. Both follow the rules of encoding/xml.
+//
+// Call is intended for vendor-specific actions and others not otherwise
+// supported by this package.
+func (d Device) Call(ctx context.Context, serviceType, action string, req, resp interface{}) error {
+	c := d.client
+	if serviceType != "" && serviceType != c.ServiceType() {
+		var err error
+		if c, err = d.serviceClient(ctx, serviceType); err != nil {
+			return err
+		}
+	}
+	return c.Call(ctx, action, req, resp)
+}
+
 func getInternalIP(loc string) (string, error) {
+	ips, err := localIPs(loc)
+	if err != nil {
+		return "", err
+	}
+	return ips[0], nil
+}
+
+// localIPs returns every IPv4 address of this host on the same network as the
+// device at loc, in order of preference.
+func localIPs(loc string) ([]string, error) {
 	// NOTE: this function makes a lot of syscalls, and we call it for *every*
 	// ServiceClient we discover, so it may be tempting to just fetch the set of
 	// interfaces once and cache them thereafter. Don't do this! Despite the
@@ -78,102 +386,423 @@ func getInternalIP(loc string) (string, error) {
 
 	baseURL, err := url.Parse(loc)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	devAddr, err := net.ResolveUDPAddr("udp4", baseURL.Host)
+	devAddr, err := net.ResolveIPAddr("ip", baseURL.Hostname())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	var ips []string
 	for _, iface := range ifaces {
+		if devAddr.Zone != "" && devAddr.Zone != iface.Name {
+			continue
+		}
 		addrs, err := iface.Addrs()
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		for _, addr := range addrs {
 			if x, ok := addr.(*net.IPNet); ok && x.Contains(devAddr.IP) {
-				return x.IP.String(), nil
+				if x.IP.To4() != nil {
+					ips = append(ips, x.IP.String())
+					continue
+				}
+				// the device was discovered via IPv6, but port mappings
+				// require an IPv4 address; use those on the same interface
+				for _, addr := range addrs {
+					if x, ok := addr.(*net.IPNet); ok && x.IP.To4() != nil {
+						ips = append(ips, x.IP.String())
+					}
+				}
 			}
 		}
 	}
-	return "", fmt.Errorf("could not find local address in same net as %v", devAddr)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("could not find local address in same net as %v", devAddr)
+	}
+	return ips, nil
+}
+
+func newDevice(c goupnp.IGDClient, o options) (Device, error) {
+	ip := o.internalIP
+	if ip == "" {
+		var err error
+		if ip, err = getInternalIP(c.Location()); err != nil {
+			return Device{}, err
+		}
+	}
+	if o.serialize {
+		c = c.Serialized()
+	}
+	c = c.WithRetries(o.retries, o.retryBackoff)
+	if o.strictLeases {
+		c = c.WithStrictLeases()
+	}
+	c = c.WithLogger(o.log)
+	if o.metrics != nil {
+		c = c.WithMetrics(o.metrics)
+	}
+	if o.clock != nil {
+		c = c.WithClock(o.clock)
+	}
+	c.AddQuirks(o.quirks)
+	return Device{
+		internalIP: ip,
+		pinnedIP:   o.internalIP != "",
+		client:     c,
+		bootID:     -1,
+		configID:   -1,
+	}, nil
+}
+
+func logClients(log Logger, url string, cs []goupnp.IGDClient, err error) {
+	if err != nil {
+		log.Debug("couldn't fetch device description", "location", url, "err", err)
+		return
+	}
+	services := make([]string, len(cs))
+	for i, c := range cs {
+		services[i] = c.ServiceType()
+	}
+	log.Debug("fetched device description", "location", url, "services", services)
+}
+
+// A DiscoveryResult is the outcome of examining a device that responded to
+// the SSDP search. Its timings allow tools to show why discovery is slow on a
+// particular network; they are zero for results served from a
+// DiscoveryCache.
+type DiscoveryResult struct {
+	// Location is the URL of the device's description.
+	Location string
+	// Device is valid if Err is nil.
+	Device Device
+	// Err describes why the device could not be used, e.g. because its
+	// description could not be fetched, or because it is not a gateway.
+	Err error
+
+	// Server is the SERVER header of the device's SSDP response.
+	Server string
+	// RTT is the time between sending the SSDP search and receiving the
+	// device's response.
+	RTT time.Duration
+	// Queued is how long the description fetch waited for one of the slots
+	// limited by WithDiscoveryConcurrency.
+	Queued time.Duration
+	// Fetch is how long it took to fetch and parse the device description.
+	Fetch time.Duration
+	// Services lists the types of the WAN connection services found in the
+	// description.
+	Services []string
 }
 
+// ErrNotGateway is reported by DiscoverResults for devices that do not offer a
+// WAN connection service, e.g. printers and smart TVs.
+var ErrNotGateway = errors.New("device is not an Internet Gateway Device")
+
 // DiscoverAll scans the local network for Devices.
-func DiscoverAll() (<-chan Device, error) {
-	locations, err := goupnp.SSDP()
+func DiscoverAll(opts ...Option) (<-chan Device, error) {
+	results, err := DiscoverResults(opts...)
 	if err != nil {
 		return nil, err
 	}
 	ch := make(chan Device)
-	go doDiscoverAll(locations, ch)
+	go func() {
+		defer close(ch)
+		for r := range results {
+			if r.Err == nil {
+				ch <- r.Device
+			}
+		}
+	}()
 	return ch, nil
 }
 
-func doDiscoverAll(locations <-chan string, devices chan<- Device) {
+// DiscoverResults is like DiscoverAll, but additionally reports each device
+// that responded to the search but could not be used, allowing callers to
+// explain why discovery came up empty.
+func DiscoverResults(opts ...Option) (<-chan DiscoveryResult, error) {
+	return DiscoverResultsCtx(context.Background(), opts...)
+}
+
+// DiscoverResultsCtx is like DiscoverResults, but stops searching and cancels
+// outstanding description fetches when ctx is done, closing the channel
+// shortly afterward.
+func DiscoverResultsCtx(ctx context.Context, opts ...Option) (<-chan DiscoveryResult, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.cache != nil {
+		if ds := o.cache.devices(); len(ds) > 0 {
+			o.log.Debug("using cached discovery results", "devices", len(ds))
+			ch := make(chan DiscoveryResult)
+			go func() {
+				for _, d := range ds {
+					ch <- DiscoveryResult{Location: d.Location(), Device: d}
+				}
+				if o.metrics != nil {
+					o.metrics.DiscoveryCompleted(len(ds))
+				}
+				close(ch)
+			}()
+			return ch, nil
+		}
+	}
+	if !o.noGatewayHint && len(o.ssdp.Addrs) == 0 && o.ssdp.Conn == nil {
+		if addr, err := defaultGatewayAddr(); err == nil {
+			o.ssdp.Unicast = append(o.ssdp.Unicast, addr)
+		} else {
+			o.log.Debug("couldn't determine default gateway", "err", err)
+		}
+	}
+	responses, err := ssdp.SearchResponses(ctx, o.ssdp)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan DiscoveryResult)
+	go doDiscoverAll(ctx, responses, ch, o)
+	return ch, nil
+}
+
+func doDiscoverAll(ctx context.Context, responses <-chan ssdp.Response, results chan<- DiscoveryResult, o options) {
+	// a router may announce several description URLs for the same device
+	var seenMu sync.Mutex
+	seen := make(map[string]bool)
+	isDup := func(c goupnp.IGDClient) bool {
+		id := c.Identity()
+		if id == "" {
+			return false
+		}
+		seenMu.Lock()
+		defer seenMu.Unlock()
+		dup := seen[id]
+		seen[id] = true
+		return dup
+	}
+	// limit the number of concurrent description fetches, so that networks
+	// with many UPnP devices don't cause a thundering herd
+	sem := make(chan struct{}, o.concurrency)
+	var found int32
 	var wg sync.WaitGroup
-	for url := range locations {
+	fetched := make(map[string]bool)
+	for r := range responses {
+		// when searching for multiple targets, a device responds to each
+		if fetched[r.Location] {
+			continue
+		}
+		fetched[r.Location] = true
+		if !o.untrusted {
+			if err := checkResponse(r, o.ssdp.Addrs); err != nil {
+				o.log.Debug("ignoring untrusted SSDP response", "location", r.Location, "raddr", r.Addr, "err", err)
+				results <- DiscoveryResult{Location: r.Location, Err: err, Server: r.Server, RTT: r.RTT}
+				continue
+			}
+		}
 		wg.Add(1)
-		go func(url string) {
+		go func(r ssdp.Response) {
 			defer wg.Done()
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			res := DiscoveryResult{Location: r.Location, Server: r.Server, RTT: r.RTT}
+			fail := func(err error) {
+				res := res
+				res.Err = err
+				results <- res
+			}
+			queued := time.Now()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				fail(fmt.Errorf("couldn't fetch device description: %w", ctx.Err()))
+				return
+			}
+			res.Queued = time.Since(queued)
+			ctx, cancel := context.WithTimeout(ctx, o.descTimeout)
 			defer cancel()
-			cs, _ := goupnp.IGDClientsByURL(ctx, url)
+			start := time.Now()
+			cs, err := goupnp.IGDClientsByURL(ctx, o.httpClient, r.Location, o.untrusted)
+			res.Fetch = time.Since(start)
+			logClients(o.log, r.Location, cs, err)
+			if err != nil {
+				fail(fmt.Errorf("couldn't fetch device description: %w", err))
+				return
+			} else if len(cs) == 0 {
+				fail(ErrNotGateway)
+				return
+			} else if len(cs) > 1 {
+				// emit the default connection first, so that Discover
+				// returns it
+				if i, ok := goupnp.DefaultConnection(ctx, cs); ok {
+					cs[0], cs[i] = cs[i], cs[0]
+				}
+			}
+			for _, c := range cs {
+				res.Services = append(res.Services, c.ServiceType())
+			}
 			for _, c := range cs {
-				if ip, err := getInternalIP(c.Location()); err == nil {
-					devices <- Device{ip, c}
+				if isDup(c) {
+					o.log.Debug("skipping duplicate service", "location", r.Location, "service", c.ServiceType())
+					continue
+				}
+				d, err := newDevice(c.WithServer(r.Server), o)
+				if err != nil {
+					o.log.Debug("couldn't determine internal IP", "location", r.Location, "err", err)
+					fail(fmt.Errorf("couldn't determine internal IP: %w", err))
+					continue
+				}
+				d.bootID, d.configID = r.BootID, r.ConfigID
+				if o.cache != nil && r.MaxAge > 0 {
+					o.cache.add(d, r.MaxAge)
 				}
+				atomic.AddInt32(&found, 1)
+				res := res
+				res.Device = d
+				results <- res
 			}
-		}(url)
+		}(r)
 	}
 	wg.Wait()
-	close(devices)
+	if o.metrics != nil {
+		o.metrics.DiscoveryCompleted(int(found))
+	}
+	close(results)
+}
+
+// DiscoverAllCtx scans the local network for Devices, returning all of them
+// once the search completes or ctx is done, whichever comes first. An error is
+// only returned if no Devices were found.
+func DiscoverAllCtx(ctx context.Context, opts ...Option) ([]Device, error) {
+	results, err := DiscoverResultsCtx(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		go func() {
+			for range results {
+			}
+		}()
+	}()
+	var devices []Device
+	var errs []string
+	for {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				return devices, noGatewayError(devices, errs)
+			} else if r.Err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v", r.Location, r.Err))
+				continue
+			}
+			devices = append(devices, r.Device)
+		case <-ctx.Done():
+			if len(devices) == 0 {
+				return nil, ctx.Err()
+			}
+			return devices, nil
+		}
+	}
+}
+
+// noGatewayError returns an error summarizing why discovery failed, or nil if
+// any devices were found.
+func noGatewayError(devices []Device, errs []string) error {
+	if len(devices) > 0 {
+		return nil
+	} else if len(errs) > 0 {
+		return fmt.Errorf("no UPnP-enabled gateway found (%v)", strings.Join(errs, "; "))
+	}
+	return errors.New("no UPnP-enabled gateway found")
 }
 
 // Discover scans the local network for Devices, reurning the first Device
-// found.
-func Discover(ctx context.Context) (Device, error) {
-	devices, err := DiscoverAll()
+// found. If no Device is found, the returned error describes why each
+// responding device was unsuitable.
+func Discover(ctx context.Context, opts ...Option) (Device, error) {
+	results, err := DiscoverResultsCtx(ctx, opts...)
 	if err != nil {
 		return Device{}, err
 	}
 	// ensure we fully consume channel
 	defer func() {
 		go func() {
-			for range devices {
+			for range results {
 			}
 		}()
 	}()
-	select {
-	case d, ok := <-devices:
-		if !ok {
-			return Device{}, errors.New("no UPnP-enabled gateway found")
+	var errs []string
+	for {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				return Device{}, noGatewayError(nil, errs)
+			} else if r.Err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v", r.Location, r.Err))
+				continue
+			}
+			return r.Device, nil
+		case <-ctx.Done():
+			return Device{}, ctx.Err()
 		}
-		return d, nil
-	case <-ctx.Done():
-		return Device{}, ctx.Err()
 	}
 }
 
-// Connect connects to the router service specified by deviceURL. Generally,
-// Connect should only be called with URLs returned by (Device).Location.
-func Connect(ctx context.Context, deviceURL string) (Device, error) {
-	clients, err := goupnp.IGDClientsByURL(ctx, deviceURL)
+// ConnectAll connects to every router service specified by deviceURL. Some
+// routers expose several services, e.g. both WANIPConnection and
+// WANPPPConnection, only one of which is typically connected.
+func ConnectAll(ctx context.Context, deviceURL string, opts ...Option) ([]Device, error) {
+	o, err := applyOptions(opts)
 	if err != nil {
-		return Device{}, err
+		return nil, err
+	}
+	clients, err := goupnp.IGDClientsByURL(ctx, o.httpClient, deviceURL, o.untrusted)
+	logClients(o.log, deviceURL, clients, err)
+	if err != nil {
+		return nil, err
+	} else if len(clients) == 0 {
+		return nil, fmt.Errorf("no UPnP-enabled gateway found at %v", deviceURL)
 	}
-	if len(clients) == 0 {
-		return Device{}, fmt.Errorf("no UPnP-enabled gateway found at %v", deviceURL)
-	} else if len(clients) > 1 {
-		return Device{}, fmt.Errorf("multiple UPnP-enabled gateways found at %v", deviceURL)
+	devices := make([]Device, len(clients))
+	for i, c := range clients {
+		if devices[i], err = newDevice(c, o); err != nil {
+			return nil, err
+		}
 	}
-	c := clients[0]
-	ip, err := getInternalIP(c.Location())
+	return devices, nil
+}
+
+// Connect connects to the router service specified by deviceURL. Generally,
+// Connect should only be called with URLs returned by (Device).Location.
+// Options that only affect SSDP discovery are ignored. If the router exposes
+// several services, Connect prefers the one the router reports as its default
+// connection, or else one whose WAN connection is up.
+func Connect(ctx context.Context, deviceURL string, opts ...Option) (Device, error) {
+	devices, err := ConnectAll(ctx, deviceURL, opts...)
 	if err != nil {
 		return Device{}, err
 	}
-	return Device{ip, c}, nil
+	return defaultDevice(ctx, devices), nil
+}
+
+// defaultDevice returns the router's default connection, if it reports one;
+// otherwise, it returns the first Device whose WAN connection is up, or the
+// first Device if none are.
+func defaultDevice(ctx context.Context, devices []Device) Device {
+	if len(devices) > 1 {
+		clients := make([]goupnp.IGDClient, len(devices))
+		for i := range devices {
+			clients[i] = devices[i].client
+		}
+		if i, ok := goupnp.DefaultConnection(ctx, clients); ok {
+			return devices[i]
+		}
+		for _, d := range devices {
+			if s, err := d.Status(ctx); err == nil && s.Connected() {
+				return d
+			}
+		}
+	}
+	return devices[0]
 }
@@ -0,0 +1,116 @@
+package upnptest
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chaos configures faults that a Server injects into its responses, allowing
+// applications to exercise their retry and reconciliation logic. Rates are
+// probabilities between 0 and 1, evaluated independently for each request.
+type Chaos struct {
+	// ErrorRate is the probability that a SOAP action fails with a bare HTTP
+	// 500 response, as routers do when overloaded. Failed actions are
+	// included in Calls.
+	ErrorRate float64
+	// TruncateRate is the probability that a response (either a SOAP response
+	// or the device description) is cut off partway through its XML.
+	TruncateRate float64
+	// WipeRate is the probability that the mapping table is wiped before a
+	// SOAP action is processed, simulating a router reboot.
+	WipeRate float64
+	// Latency is the delay before each response. If LatencyJitter is
+	// non-zero, a random duration of up to LatencyJitter is added.
+	Latency       time.Duration
+	LatencyJitter time.Duration
+	// ConflictPorts are external ports that always fail AddPortMapping with
+	// ConflictInMappingEntry, as if they were mapped to another host.
+	ConflictPorts []uint16
+	// Seed seeds the random source, making the injected faults repeatable. If
+	// zero, a random seed is used.
+	Seed int64
+}
+
+// SetChaos configures the faults injected by the Server. The zero Chaos
+// disables them.
+func (s *Server) SetChaos(c Chaos) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s.chaos = c
+	s.rand = rand.New(rand.NewSource(seed))
+}
+
+// isConflictPort reports whether AddPortMapping should fail for port. s.mu must
+// be held.
+func (s *Server) isConflictPort(port uint16) bool {
+	for _, p := range s.chaos.ConflictPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// chaotic wraps h, injecting the faults configured via SetChaos. Errors and
+// wipes are only injected into SOAP actions.
+func (s *Server) chaotic(h http.HandlerFunc, soapAction bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		c := s.chaos
+		roll := func(rate float64) bool { return rate > 0 && s.rand.Float64() < rate }
+		delay := c.Latency
+		if c.LatencyJitter > 0 {
+			delay += time.Duration(s.rand.Int63n(int64(c.LatencyJitter)))
+		}
+		fail := soapAction && roll(c.ErrorRate)
+		wipe := soapAction && roll(c.WipeRate)
+		truncate := roll(c.TruncateRate)
+		s.mu.Unlock()
+
+		if delay > 0 {
+			t := time.NewTimer(delay)
+			select {
+			case <-t.C:
+			case <-req.Context().Done():
+				t.Stop()
+				return
+			}
+		}
+		if wipe {
+			s.Reboot()
+		}
+		if fail {
+			// count the attempt, so that tests can observe retries
+			hdr := req.Header.Get("SOAPAction")
+			if i := strings.LastIndexByte(hdr, '#'); i >= 0 {
+				s.mu.Lock()
+				s.calls[strings.Trim(hdr[i+1:], `"`)]++
+				s.mu.Unlock()
+			}
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !truncate {
+			h(w, req)
+			return
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		body := rec.Body.Bytes()
+		body = body[:len(body)/2]
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(rec.Code)
+		w.Write(body)
+	}
+}
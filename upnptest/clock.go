@@ -0,0 +1,130 @@
+package upnptest
+
+import (
+	"sync"
+	"time"
+
+	"lukechampine.com/upnp"
+)
+
+// A Clock is a fake upnp.Clock whose time only changes when Advance is
+// called. Passing it to upnp.WithClock (and Server.SetClock) allows lease
+// renewals, reconciliation passes, and the like to be tested without real
+// sleeps.
+type Clock struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewClock returns a Clock set to the specified time.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.cond.L = &c.mu
+	return c
+}
+
+// Now implements upnp.Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer implements upnp.Clock.
+func (c *Clock) NewTimer(d time.Duration) upnp.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1)}
+	c.schedule(t, d)
+	return t
+}
+
+// schedule arms t to fire after d. c.mu must be held.
+func (c *Clock) schedule(t *fakeTimer, d time.Duration) {
+	t.when = c.now.Add(d)
+	if d <= 0 {
+		c.fire(t)
+		return
+	}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+}
+
+// fire sends the current time on t's channel. As with time.Timer, the send is
+// dropped if the previous value has not been received.
+func (c *Clock) fire(t *fakeTimer) {
+	select {
+	case t.ch <- c.now:
+	default:
+	}
+}
+
+// unschedule disarms t, reporting whether it was armed. c.mu must be held.
+func (c *Clock) unschedule(t *fakeTimer) bool {
+	for i, u := range c.timers {
+		if u == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d, firing any timers that expire in the
+// meantime.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	remaining := c.timers[:0]
+	for _, t := range c.timers {
+		if !t.when.After(c.now) {
+			c.fire(t)
+		} else {
+			remaining = append(remaining, t)
+		}
+	}
+	c.timers = remaining
+}
+
+// Timers returns the number of timers that are armed but have not yet fired.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n timers are armed. Tests typically call
+// it before Advance, to ensure that the code under test is waiting on the
+// clock.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	c    *Clock
+	ch   chan time.Time
+	when time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.c.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.c.unschedule(t)
+	t.c.schedule(t, d)
+	return active
+}
@@ -0,0 +1,117 @@
+package upnptest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// subscriber is a GENA event subscriber.
+type subscriber struct {
+	callback string
+	queue    chan string // event bodies, delivered in order
+}
+
+// deliver sends queued events to the subscriber's callback URL until the
+// queue is closed.
+func (sub *subscriber) deliver(sid string) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	seq := 0
+	for body := range sub.queue {
+		req, err := http.NewRequest("NOTIFY", sub.callback, strings.NewReader(body))
+		if err != nil {
+			continue
+		}
+		req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+		req.Header.Set("NT", "upnp:event")
+		req.Header.Set("NTS", "upnp:propchange")
+		req.Header.Set("SID", sid)
+		req.Header.Set("SEQ", strconv.Itoa(seq))
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		seq++
+	}
+}
+
+// handleEvents implements GENA subscriptions to the PortMappingNumberOfEntries
+// state variable, the only evented variable the Server supports.
+func (s *Server) handleEvents(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sid := req.Header.Get("SID")
+	switch req.Method {
+	case "SUBSCRIBE":
+		if sid != "" {
+			// renewal
+			if _, ok := s.subs[sid]; !ok {
+				http.Error(w, "unknown SID", http.StatusPreconditionFailed)
+				return
+			}
+			w.Header().Set("SID", sid)
+			w.Header().Set("TIMEOUT", "Second-1800")
+			return
+		}
+		callback := strings.Trim(req.Header.Get("CALLBACK"), "<>")
+		if req.Header.Get("NT") != "upnp:event" || !strings.HasPrefix(callback, "http://") {
+			http.Error(w, "invalid NT or CALLBACK header", http.StatusPreconditionFailed)
+			return
+		}
+		s.nextSID++
+		sid = fmt.Sprintf("uuid:upnptest-sub-%d", s.nextSID)
+		sub := &subscriber{callback: callback, queue: make(chan string, 16)}
+		s.subs[sid] = sub
+		go sub.deliver(sid)
+		w.Header().Set("SID", sid)
+		w.Header().Set("TIMEOUT", "Second-1800")
+		// the initial event contains the current value
+		s.expire()
+		sub.queue <- entriesEvent(len(s.mappings))
+
+	case "UNSUBSCRIBE":
+		sub, ok := s.subs[sid]
+		if !ok {
+			http.Error(w, "unknown SID", http.StatusPreconditionFailed)
+			return
+		}
+		delete(s.subs, sid)
+		close(sub.queue)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func entriesEvent(n int) string {
+	return `<?xml version="1.0"?>
+<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0"><e:property><PortMappingNumberOfEntries>` + strconv.Itoa(n) + `</PortMappingNumberOfEntries></e:property></e:propertyset>`
+}
+
+// notifyEntries sends an event to each subscriber if the number of mappings
+// has changed since the last event. s.mu must be held.
+func (s *Server) notifyEntries() {
+	if len(s.mappings) == s.notifiedEntries {
+		return
+	}
+	s.notifiedEntries = len(s.mappings)
+	body := entriesEvent(s.notifiedEntries)
+	for _, sub := range s.subs {
+		select {
+		case sub.queue <- body:
+		default:
+			// subscriber is too slow; drop the event
+		}
+	}
+}
+
+// closeSubscriptions stops delivering events to every subscriber.
+func (s *Server) closeSubscriptions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sid, sub := range s.subs {
+		delete(s.subs, sid)
+		close(sub.queue)
+	}
+}
@@ -0,0 +1,96 @@
+package upnptest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// A Responder answers SSDP searches on behalf of a device. Searches must be
+// sent directly to the Responder's address, e.g. via upnp.WithSearchAddrs.
+type Responder struct {
+	conn     net.PacketConn
+	location string
+	usn      string
+	done     chan struct{}
+}
+
+// NewResponder starts a Responder listening on loopback that directs searchers
+// to the specified device description, typically Server.Location.
+func NewResponder(location string) (*Responder, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return NewResponderConn(conn, location), nil
+}
+
+// NewResponderConn is like NewResponder, but answers searches received on the
+// provided conn. The Responder takes ownership of conn.
+func NewResponderConn(conn net.PacketConn, location string) *Responder {
+	r := &Responder{
+		conn:     conn,
+		location: location,
+		usn:      "uuid:" + conn.LocalAddr().String() + "::upnp:rootdevice",
+		done:     make(chan struct{}),
+	}
+	go r.serve()
+	return r
+}
+
+// Addr returns the address the Responder is listening on.
+func (r *Responder) Addr() string {
+	return r.conn.LocalAddr().String()
+}
+
+// Close stops the Responder.
+func (r *Responder) Close() error {
+	err := r.conn.Close()
+	<-r.done
+	return err
+}
+
+// matchesTarget reports whether a device should respond to the specified
+// search target.
+func matchesTarget(st string) bool {
+	switch st {
+	case "ssdp:all", "upnp:rootdevice":
+		return true
+	default:
+		return strings.HasPrefix(st, "urn:schemas-upnp-org:device:InternetGatewayDevice:") ||
+			strings.HasPrefix(st, "urn:schemas-upnp-org:service:WANIPConnection:")
+	}
+}
+
+func (r *Responder) serve() {
+	defer close(r.done)
+	packet := make([]byte, 2048)
+	for {
+		n, addr, err := r.conn.ReadFrom(packet)
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(packet[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		st := req.Header.Get("ST")
+		if !matchesTarget(st) {
+			continue
+		}
+		resp := strings.Replace(fmt.Sprintf(`
+HTTP/1.1 200 OK
+CACHE-CONTROL: max-age=1800
+EXT:
+LOCATION: %v
+SERVER: upnptest UPnP/1.1
+ST: %v
+USN: %v
+
+`[1:], r.location, st, r.usn), "\n", "\r\n", -1)
+		r.conn.WriteTo([]byte(resp), addr)
+	}
+}
@@ -0,0 +1,461 @@
+// Package upnptest provides a fake Internet Gateway Device for testing code
+// that uses the upnp package.
+package upnptest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lukechampine.com/upnp"
+	"lukechampine.com/upnp/igd"
+	"lukechampine.com/upnp/soap"
+)
+
+const (
+	descPath    = "/rootDesc.xml"
+	controlPath = "/ctl/IPConn"
+	eventPath   = "/evt/IPConn"
+)
+
+type mappingKey struct {
+	port  uint16
+	proto string
+}
+
+type mapping struct {
+	upnp.PortMapping
+	expiry time.Time // zero for permanent mappings
+}
+
+// A Server is a fake IGD that serves a device description and implements the
+// WANIPConnection:1 service against an in-memory mapping table. Mappings with
+// a lease expire in real time, unless SetClock is used. Subscribers to the
+// service's events are notified whenever the number of mappings changes.
+type Server struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	externalIP  string
+	natDisabled bool
+	terminated  bool
+	connType    string
+	mappings    map[mappingKey]mapping
+	faults      map[string]*soap.UPnPError
+	calls       map[string]int
+	clock       upnp.Clock
+	chaos       Chaos
+	rand        *rand.Rand
+
+	subs            map[string]*subscriber
+	nextSID         int
+	notifiedEntries int
+}
+
+// NewServer starts and returns a new Server listening on loopback. The caller
+// should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		externalIP: "203.0.113.1",
+		connType:   "IP_Routed",
+		mappings:   make(map[mappingKey]mapping),
+		faults:     make(map[string]*soap.UPnPError),
+		calls:      make(map[string]int),
+		subs:       make(map[string]*subscriber),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(descPath, s.chaotic(s.handleDescription, false))
+	mux.HandleFunc(controlPath, s.chaotic(s.handleControl, true))
+	mux.HandleFunc(eventPath, s.handleEvents)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.closeSubscriptions()
+	s.srv.Close()
+}
+
+// Location returns the URL of the server's device description, suitable for
+// passing to upnp.Connect.
+func (s *Server) Location() string {
+	return s.srv.URL + descPath
+}
+
+// UDN returns the Unique Device Name reported in the server's description.
+func (s *Server) UDN() string {
+	return "uuid:upnptest-" + strings.TrimPrefix(s.srv.URL, "http://")
+}
+
+// SetExternalIP sets the address returned by GetExternalIPAddress. The default
+// is 203.0.113.1.
+func (s *Server) SetExternalIP(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.externalIP = ip
+}
+
+// SetNATEnabled sets the NAT status returned by GetNATRSIPStatus. NAT is
+// enabled by default.
+func (s *Server) SetNATEnabled(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.natDisabled = !enabled
+}
+
+// SetFault causes every subsequent invocation of the specified action to fail
+// with err. A nil err removes the fault.
+func (s *Server) SetFault(action string, err *soap.UPnPError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.faults, action)
+	} else {
+		s.faults[action] = err
+	}
+}
+
+// Calls returns the number of times the specified action has been invoked.
+func (s *Server) Calls(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[action]
+}
+
+// Mappings returns the current mapping table, sorted by protocol and external
+// port.
+func (s *Server) Mappings() []upnp.PortMapping {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms := s.sortedMappings()
+	pms := make([]upnp.PortMapping, len(ms))
+	for i, m := range ms {
+		pms[i] = m.PortMapping
+	}
+	return pms
+}
+
+// AddMapping inserts a mapping directly into the table, as if it had been
+// created by another host.
+func (s *Server) AddMapping(m upnp.PortMapping) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mappings[mappingKey{m.ExternalPort, m.Protocol}] = s.newMapping(m)
+	s.notifyEntries()
+}
+
+// Reboot clears the mapping table, as many routers do when restarted.
+func (s *Server) Reboot() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mappings = make(map[mappingKey]mapping)
+	s.notifyEntries()
+}
+
+// SetClock causes leased mappings to expire according to c rather than in real
+// time.
+func (s *Server) SetClock(c upnp.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+}
+
+func (s *Server) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+func (s *Server) newMapping(pm upnp.PortMapping) mapping {
+	m := mapping{PortMapping: pm}
+	if pm.Lease > 0 {
+		m.expiry = s.now().Add(pm.Lease)
+	}
+	return m
+}
+
+// expire removes expired mappings and updates the remaining lease of the rest.
+func (s *Server) expire() {
+	now := s.now()
+	for k, m := range s.mappings {
+		if m.expiry.IsZero() {
+			continue
+		} else if !now.Before(m.expiry) {
+			delete(s.mappings, k)
+		} else {
+			m.Lease = m.expiry.Sub(now)
+			s.mappings[k] = m
+		}
+	}
+}
+
+func (s *Server) sortedMappings() []mapping {
+	s.expire()
+	ms := make([]mapping, 0, len(s.mappings))
+	for _, m := range s.mappings {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Protocol != ms[j].Protocol {
+			return ms[i].Protocol < ms[j].Protocol
+		}
+		return ms[i].ExternalPort < ms[j].ExternalPort
+	})
+	return ms
+}
+
+func (s *Server) handleDescription(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+		<friendlyName>upnptest</friendlyName>
+		<manufacturer>upnptest</manufacturer>
+		<modelName>upnptest</modelName>
+		<UDN>%s</UDN>
+		<deviceList>
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+				<deviceList>
+					<device>
+						<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+						<serviceList>
+							<service>
+								<serviceType>%s</serviceType>
+								<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+								<controlURL>%s</controlURL>
+								<eventSubURL>%s</eventSubURL>
+							</service>
+						</serviceList>
+					</device>
+				</deviceList>
+			</device>
+		</deviceList>
+	</device>
+</root>`, s.UDN(), igd.WANIPConnection1, controlPath, eventPath)
+}
+
+// parseAction returns the name and arguments of a SOAP request.
+func parseAction(body []byte) (string, map[string]string, error) {
+	var env struct {
+		Body struct {
+			Action struct {
+				XMLName xml.Name
+				Args    []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}
+	if err := xml.Unmarshal(body, &env); err != nil {
+		return "", nil, err
+	}
+	args := make(map[string]string)
+	for _, arg := range env.Body.Action.Args {
+		args[arg.XMLName.Local] = strings.TrimSpace(arg.Value)
+	}
+	return env.Body.Action.XMLName.Local, args, nil
+}
+
+// writeResponse writes a SOAP response containing the specified output
+// arguments, which alternate between names and values.
+func writeResponse(w http.ResponseWriter, action string, args ...string) {
+	var sb strings.Builder
+	for i := 0; i+1 < len(args); i += 2 {
+		sb.WriteString("<" + args[i] + ">")
+		xml.EscapeText(&sb, []byte(args[i+1]))
+		sb.WriteString("</" + args[i] + ">")
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body>
+</s:Envelope>`, action, igd.WANIPConnection1, sb.String(), action)
+}
+
+// writeFault writes a SOAP fault containing the specified UPnP error.
+func writeFault(w http.ResponseWriter, err *soap.UPnPError) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	var desc strings.Builder
+	xml.EscapeText(&desc, []byte(err.Description))
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>
+<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>
+</detail></s:Fault></s:Body>
+</s:Envelope>`, err.Code, desc.String())
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func parseBool(s string) bool {
+	return s == "1" || strings.EqualFold(s, "true") || strings.EqualFold(s, "yes")
+}
+
+func leaseString(lease time.Duration) string {
+	return strconv.Itoa(int((lease + time.Second - 1) / time.Second))
+}
+
+func (s *Server) handleControl(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return
+	}
+	action, args, err := parseAction(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.notifyEntries()
+	s.calls[action]++
+	if f, ok := s.faults[action]; ok {
+		writeFault(w, f)
+		return
+	}
+	s.expire()
+
+	port, _ := strconv.ParseUint(args["NewExternalPort"], 10, 16)
+	key := mappingKey{uint16(port), args["NewProtocol"]}
+	switch action {
+	case "GetExternalIPAddress":
+		writeResponse(w, action, "NewExternalIPAddress", s.externalIP)
+
+	case "GetStatusInfo":
+		status := "Connected"
+		if s.terminated {
+			status = "Disconnected"
+		}
+		writeResponse(w, action,
+			"NewConnectionStatus", status,
+			"NewLastConnectionError", "ERROR_NONE",
+			"NewUptime", "3600")
+
+	case "RequestConnection":
+		s.terminated = false
+		writeResponse(w, action)
+
+	case "ForceTermination":
+		s.terminated = true
+		writeResponse(w, action)
+
+	case "GetConnectionTypeInfo":
+		writeResponse(w, action,
+			"NewConnectionType", s.connType,
+			"NewPossibleConnectionTypes", "Unconfigured,IP_Routed,IP_Bridged")
+
+	case "SetConnectionType":
+		switch t := args["NewConnectionType"]; t {
+		case "Unconfigured", "IP_Routed", "IP_Bridged":
+			s.connType = t
+			writeResponse(w, action)
+		default:
+			writeFault(w, upnp.ErrInvalidArgs)
+		}
+
+	case "GetNATRSIPStatus":
+		writeResponse(w, action,
+			"NewRSIPAvailable", "0",
+			"NewNATEnabled", formatBool(!s.natDisabled))
+
+	case "AddPortMapping":
+		internalPort, _ := strconv.ParseUint(args["NewInternalPort"], 10, 16)
+		lease, _ := strconv.ParseUint(args["NewLeaseDuration"], 10, 32)
+		if key.port == 0 {
+			writeFault(w, upnp.ErrWildCardNotPermittedInExtPort)
+			return
+		} else if key.proto != "TCP" && key.proto != "UDP" {
+			writeFault(w, upnp.ErrInvalidArgs)
+			return
+		} else if m, ok := s.mappings[key]; (ok && m.InternalClient != args["NewInternalClient"]) || s.isConflictPort(key.port) {
+			writeFault(w, upnp.ErrConflictInMappingEntry)
+			return
+		}
+		s.mappings[key] = s.newMapping(upnp.PortMapping{
+			ExternalPort:   key.port,
+			InternalPort:   uint16(internalPort),
+			InternalClient: args["NewInternalClient"],
+			Protocol:       key.proto,
+			Description:    args["NewPortMappingDescription"],
+			Enabled:        parseBool(args["NewEnabled"]),
+			Lease:          time.Duration(lease) * time.Second,
+		})
+		writeResponse(w, action)
+
+	case "DeletePortMapping":
+		if _, ok := s.mappings[key]; !ok {
+			writeFault(w, upnp.ErrNoSuchEntryInArray)
+			return
+		}
+		delete(s.mappings, key)
+		writeResponse(w, action)
+
+	case "GetSpecificPortMappingEntry":
+		m, ok := s.mappings[key]
+		if !ok {
+			writeFault(w, upnp.ErrNoSuchEntryInArray)
+			return
+		}
+		writeResponse(w, action,
+			"NewInternalPort", strconv.Itoa(int(m.InternalPort)),
+			"NewInternalClient", m.InternalClient,
+			"NewEnabled", formatBool(m.Enabled),
+			"NewPortMappingDescription", m.Description,
+			"NewLeaseDuration", leaseString(m.Lease))
+
+	case "GetGenericPortMappingEntry":
+		index, err := strconv.Atoi(args["NewPortMappingIndex"])
+		ms := s.sortedMappings()
+		if err != nil || index < 0 || index >= len(ms) {
+			writeFault(w, upnp.ErrSpecifiedArrayIndexInvalid)
+			return
+		}
+		m := ms[index]
+		writeResponse(w, action,
+			"NewRemoteHost", "",
+			"NewExternalPort", strconv.Itoa(int(m.ExternalPort)),
+			"NewProtocol", m.Protocol,
+			"NewInternalPort", strconv.Itoa(int(m.InternalPort)),
+			"NewInternalClient", m.InternalClient,
+			"NewEnabled", formatBool(m.Enabled),
+			"NewPortMappingDescription", m.Description,
+			"NewLeaseDuration", leaseString(m.Lease))
+
+	case "QueryStateVariable":
+		if args["varName"] != "PortMappingNumberOfEntries" {
+			writeFault(w, errInvalidVar)
+			return
+		}
+		writeResponse(w, action, "return", strconv.Itoa(len(s.mappings)))
+
+	default:
+		writeFault(w, upnp.ErrInvalidAction)
+	}
+}
+
+var errInvalidVar = &upnp.UPnPError{Code: 404, Description: "Invalid Var"}
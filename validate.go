@@ -0,0 +1,89 @@
+package upnp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"lukechampine.com/upnp/internal/goupnp"
+	"lukechampine.com/upnp/ssdp"
+)
+
+// ErrUntrustedDevice is reported by DiscoverResults and Connect for devices
+// whose SSDP response came from outside the local network, or whose
+// description, control, event, or SCPD URLs point outside of it. A malicious
+// host could otherwise direct the client to send requests to arbitrary
+// servers.
+var ErrUntrustedDevice = goupnp.ErrUntrustedDevice
+
+// onLocalSubnet reports whether ip is on a subnet that one of this host's
+// interfaces is directly connected to.
+func onLocalSubnet(ip net.IP) (bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false, err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return false, err
+		}
+		for _, addr := range addrs {
+			if x, ok := addr.(*net.IPNet); ok && x.Contains(ip) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// checkResponse returns an error if r did not come from a directly-connected
+// subnet (or one of the addresses the search was sent to), or if its LOCATION
+// does not point at a private or link-local address.
+func checkResponse(r ssdp.Response, searchAddrs []*net.UDPAddr) error {
+	if ua, ok := r.Addr.(*net.UDPAddr); ok {
+		trusted := false
+		for _, sa := range searchAddrs {
+			trusted = trusted || sa.IP.Equal(ua.IP)
+		}
+		if !trusted {
+			local, err := onLocalSubnet(ua.IP)
+			if err != nil {
+				return err
+			} else if !local {
+				return fmt.Errorf("%w: response came from %v", ErrUntrustedDevice, ua.IP)
+			}
+		}
+	}
+	u, err := url.Parse(r.Location)
+	if err != nil {
+		return err
+	}
+	if !goupnp.IsLocalHost(u.Hostname()) {
+		return fmt.Errorf("%w: location host %q is not a private address", ErrUntrustedDevice, u.Hostname())
+	}
+	return nil
+}
+
+// restrictRedirects returns a copy of client that refuses to follow redirects
+// to a different host, unless that host is a private or link-local address.
+func restrictRedirects(client *http.Client) *http.Client {
+	cc := *client
+	check := cc.CheckRedirect
+	cc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != via[0].URL.Host {
+			if !goupnp.IsLocalHost(req.URL.Hostname()) {
+				return fmt.Errorf("%w: refusing to follow redirect to %v", ErrUntrustedDevice, req.URL.Host)
+			}
+		}
+		if check != nil {
+			return check(req, via)
+		} else if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &cc
+}
@@ -0,0 +1,56 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// A VerificationError is returned by ForwardVerified when a router accepts a
+// mapping request but does not actually apply it as requested.
+type VerificationError struct {
+	Requested PortMapping
+	// Actual is the mapping the router reports, or nil if the router reports
+	// no mapping at all.
+	Actual *PortMapping
+}
+
+// Error implements error.
+func (e *VerificationError) Error() string {
+	r := e.Requested
+	if e.Actual == nil {
+		return fmt.Sprintf("router silently dropped mapping for %v port %v", r.Protocol, r.ExternalPort)
+	}
+	a := *e.Actual
+	return fmt.Sprintf("router rewrote mapping for %v port %v: requested %v:%v (enabled=%v), got %v:%v (enabled=%v)",
+		r.Protocol, r.ExternalPort, r.InternalClient, r.InternalPort, r.Enabled, a.InternalClient, a.InternalPort, a.Enabled)
+}
+
+// ForwardVerified is like Forward, but after the router accepts the mapping,
+// ForwardVerified queries it again to confirm that it was actually applied. If
+// the mapping is missing, or points somewhere other than requested, a
+// *VerificationError is returned.
+func (d Device) ForwardVerified(ctx context.Context, port uint16, proto string, desc string, opts ...ForwardOption) error {
+	if err := d.Forward(ctx, port, proto, desc, opts...); err != nil {
+		return err
+	}
+	req, err := d.mappingRequest(port, proto, desc, opts)
+	if err != nil {
+		return err
+	}
+	requested := requestedMapping(req)
+	actual, err := d.specificMapping(ctx, port, proto)
+	if errors.Is(err, ErrNoSuchEntryInArray) {
+		return &VerificationError{Requested: requested}
+	} else if err != nil {
+		return fmt.Errorf("couldn't verify mapping: %w", err)
+	}
+	// NOTE: descriptions and leases are not compared, since many routers
+	// truncate the former and round the latter
+	if actual.InternalClient != requested.InternalClient ||
+		actual.InternalPort != requested.InternalPort ||
+		actual.Enabled != requested.Enabled {
+		return &VerificationError{Requested: requested, Actual: &actual}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package upnp
+
+import (
+	"context"
+	"time"
+
+	"lukechampine.com/upnp/internal/clock"
+	"lukechampine.com/upnp/ssdp"
+)
+
+// A WatchEventType indicates whether a device appeared or disappeared.
+type WatchEventType int
+
+// Watch event types.
+const (
+	DeviceAppeared WatchEventType = iota
+	DeviceDisappeared
+	// DeviceRebooted indicates that a device announced a new BOOTID, meaning
+	// that it rebooted (or otherwise lost its state). Gateways lose their
+	// port mappings when they reboot, so they must be re-created.
+	DeviceRebooted
+)
+
+// String implements fmt.Stringer.
+func (t WatchEventType) String() string {
+	switch t {
+	case DeviceAppeared:
+		return "appeared"
+	case DeviceDisappeared:
+		return "disappeared"
+	case DeviceRebooted:
+		return "rebooted"
+	default:
+		return "unknown"
+	}
+}
+
+// A WatchEvent reports a change in the set of devices on the local network.
+type WatchEvent struct {
+	Type WatchEventType
+	// USN is the Unique Service Name of the root device.
+	USN string
+	// Location is the URL of the device's description, suitable for passing
+	// to Connect. It is empty for DeviceDisappeared events.
+	Location string
+	// BootID is the device's BOOTID.UPNP.ORG value, or -1 if unknown.
+	BootID int
+}
+
+// Watch passively listens for SSDP announcements, reporting root devices as
+// they join and leave the network. A device is reported as having disappeared
+// when it sends an ssdp:byebye message, or when it fails to re-announce itself
+// before its announcement expires. UPnP 1.1 devices are additionally reported
+// as having rebooted when they announce a new BOOTID.
+//
+// Watch reports every root device, not just gateways; callers can determine
+// whether a device is a gateway by passing its Location to Connect. The
+// returned channel is closed when ctx is canceled. Of the supplied options,
+// only WithClock applies.
+func Watch(ctx context.Context, opts ...Option) (<-chan WatchEvent, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	notifies, err := ssdp.ListenNotify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan WatchEvent)
+	go doWatch(ctx, notifies, events, clock.Or(o.clock))
+	return events, nil
+}
+
+func doWatch(ctx context.Context, notifies <-chan ssdp.Notify, events chan<- WatchEvent, clk clock.Clock) {
+	defer close(events)
+	// devices that don't specify a max-age are assumed to use the recommended
+	// value
+	const defaultMaxAge = 30 * time.Minute
+	expiry := make(map[string]time.Time)
+	// retained after a device disappears, so that a reboot is detected even
+	// if the device says goodbye first
+	bootIDs := make(map[string]int)
+	bootID := func(usn string) int {
+		if id, ok := bootIDs[usn]; ok {
+			return id
+		}
+		return -1
+	}
+	send := func(e WatchEvent) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	const expiryInterval = 5 * time.Second
+	timer := clk.NewTimer(expiryInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case n, ok := <-notifies:
+			if !ok {
+				return
+			} else if n.NT != "upnp:rootdevice" || n.USN == "" {
+				continue
+			}
+			switch n.NTS {
+			case "ssdp:alive":
+				maxAge := n.MaxAge
+				if maxAge == 0 {
+					maxAge = defaultMaxAge
+				}
+				_, known := expiry[n.USN]
+				expiry[n.USN] = clk.Now().Add(maxAge)
+				if !known && !send(WatchEvent{DeviceAppeared, n.USN, n.Location, n.BootID}) {
+					return
+				}
+				if n.BootID >= 0 {
+					prev, ok := bootIDs[n.USN]
+					bootIDs[n.USN] = n.BootID
+					if ok && prev != n.BootID && !send(WatchEvent{DeviceRebooted, n.USN, n.Location, n.BootID}) {
+						return
+					}
+				}
+			case "ssdp:update":
+				// the device's BOOTID is changing without it losing state,
+				// e.g. because it joined another network
+				if n.NextBootID >= 0 {
+					bootIDs[n.USN] = n.NextBootID
+				}
+			case "ssdp:byebye":
+				if _, known := expiry[n.USN]; known {
+					delete(expiry, n.USN)
+					if !send(WatchEvent{DeviceDisappeared, n.USN, "", bootID(n.USN)}) {
+						return
+					}
+				}
+			}
+		case now := <-timer.C():
+			timer.Reset(expiryInterval)
+			for usn, exp := range expiry {
+				if now.After(exp) {
+					delete(expiry, usn)
+					if !send(WatchEvent{DeviceDisappeared, usn, "", bootID(usn)}) {
+						return
+					}
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}